@@ -0,0 +1,215 @@
+package approval
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 命名地址列表，用于inList/notInList规则，如交易所、金库地址
+type AddressList struct {
+	Name      string
+	File      string   // json或csv文件，json为地址数组或{"address": ...}对象数组，csv第一列为地址
+	Addresses []string // 直接配置的地址，与文件中的地址合并
+}
+
+// 注册地址列表，相同名称时覆盖
+func (r *PolicyResources) RegisterAddressList(name string, addresses []string) error {
+	if name == "" {
+		return fmt.Errorf("address list name is required")
+	}
+	var list []string
+	for _, addr := range addresses {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		if isBfcAddress(addr) {
+			if _, err := normalizeBfcAddress(addr); err != nil {
+				return fmt.Errorf("address list %s: %w", name, err)
+			}
+		}
+		list = append(list, addr)
+	}
+	r.lists[name] = list
+	r.sources = append(r.sources, "list:"+name+":"+strings.Join(list, ","))
+	return nil
+}
+
+// 加载配置的地址列表，File和Addresses中的地址合并后注册
+func (r *PolicyResources) LoadAddressList(l AddressList) error {
+	addresses := append([]string(nil), l.Addresses...)
+	if l.File != "" {
+		fileAddresses, err := readAddressFile(l.File)
+		if err != nil {
+			return fmt.Errorf("address list %s: %w", l.Name, err)
+		}
+		addresses = append(addresses, fileAddresses...)
+	}
+	return r.RegisterAddressList(l.Name, addresses)
+}
+
+func readAddressFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		var addresses []string
+		for i, item := range items {
+			var addr string
+			if err := json.Unmarshal(item, &addr); err != nil {
+				var obj struct {
+					Address string `json:"address"`
+				}
+				if err := json.Unmarshal(item, &obj); err != nil || obj.Address == "" {
+					return nil, fmt.Errorf("%s: item %d is not an address", path, i)
+				}
+				addr = obj.Address
+			}
+			addresses = append(addresses, addr)
+		}
+		return addresses, nil
+	}
+
+	// csv: 第一列为地址，忽略空行、#开头的注释和address表头
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	var addresses []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		addr := strings.TrimSpace(record[0])
+		if addr == "" || strings.EqualFold(addr, "address") {
+			continue
+		}
+		addresses = append(addresses, addr)
+	}
+	return addresses, nil
+}
+
+// 查询地址列表，value可以是逗号分隔的多个列表名
+func (r *PolicyResources) lookupAddressLists(value string) ([]string, error) {
+	var addresses []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		list, ok := r.lists[name]
+		if !ok {
+			return nil, fmt.Errorf("address list %q not found", name)
+		}
+		addresses = append(addresses, list...)
+	}
+	return addresses, nil
+}
+
+/*
+  - 按链规范化地址，用于地址比较
+    EVM链不区分大小写（忽略EIP-55校验和），Solana使用base58原值，Benfen补齐为0x开头的64位hex
+    BFC前缀的Benfen地址校验和错误时返回错误
+*/
+func normalizeAddress(chain, addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	switch {
+	case isEvmChain(chain):
+		addr = strings.ToLower(addr)
+		if !strings.HasPrefix(addr, "0x") {
+			addr = "0x" + addr
+		}
+		return addr, nil
+	case strings.EqualFold(chain, BENFEN) || strings.EqualFold(chain, BENFEN_TESTNET):
+		if isBfcAddress(addr) {
+			return normalizeBfcAddress(addr)
+		}
+		addr = strings.TrimPrefix(strings.ToLower(addr), "0x")
+		if len(addr) < 64 {
+			addr = strings.Repeat("0", 64-len(addr)) + addr
+		}
+		return "0x" + addr, nil
+	}
+	return addr, nil
+}
+
+// BFC前缀 + 32字节地址hex + 4位校验和
+func isBfcAddress(addr string) bool {
+	return len(addr) == 3+64+4 && strings.EqualFold(addr[:3], "bfc")
+}
+
+// 校验BFC地址的校验和（地址hex的sha256前4位），返回0x开头的地址
+func normalizeBfcAddress(addr string) (string, error) {
+	addr = strings.ToLower(addr)
+	hexAddr, checksum := addr[3:3+64], addr[3+64:]
+	if _, err := hex.DecodeString(hexAddr); err != nil {
+		return "", fmt.Errorf("invalid benfen address %s", addr)
+	}
+	sum := sha256.Sum256([]byte(hexAddr))
+	if hex.EncodeToString(sum[:2]) != checksum {
+		return "", fmt.Errorf("invalid benfen address %s, checksum mismatch", addr)
+	}
+	return "0x" + hexAddr, nil
+}
+
+// 按链规范化后的地址集合，注册时已校验BFC地址，忽略无法规范化的地址
+func addressSet(chain string, list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, addr := range list {
+		if normalized, err := normalizeAddress(chain, addr); err == nil {
+			set[normalized] = true
+		}
+	}
+	return set
+}
+
+// 检查inList/notInList规则，actual为列表时检查所有元素
+func checkAddressSet(chain string, set map[string]bool, actual interface{}, value, rule string) (bool, string) {
+	var addresses []string
+	switch v := actual.(type) {
+	case string:
+		addresses = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return false, fmt.Sprintf("unsupported value type %T", item)
+			}
+			addresses = append(addresses, s)
+		}
+	default:
+		return false, fmt.Sprintf("unsupported value type %T", actual)
+	}
+
+	// 空列表时inList不通过，避免配置错误时放行所有地址
+	if rule == "inList" && len(set) == 0 {
+		return false, fmt.Sprintf("address list %s is empty", value)
+	}
+	for _, addr := range addresses {
+		normalized, err := normalizeAddress(chain, addr)
+		if err != nil {
+			return false, err.Error()
+		}
+		in := set[normalized]
+		if rule == "inList" && !in {
+			return false, fmt.Sprintf("address %s not in list %s", addr, value)
+		}
+		if rule == "notInList" && in {
+			return false, fmt.Sprintf("address %s in list %s", addr, value)
+		}
+	}
+	return true, ""
+}
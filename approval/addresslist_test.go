@@ -0,0 +1,103 @@
+package approval
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressList(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "treasury.json")
+	assert.NoError(t, os.WriteFile(jsonFile, []byte(`["0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", {"address": "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU", "label": "sol treasury"}]`), 0644))
+	csvFile := filepath.Join(dir, "exchanges.csv")
+	assert.NoError(t, os.WriteFile(csvFile, []byte("address,label\n# binance\n0x28C6c06298d514Db089934071355E5743bf21d60, binance 14\n\n0x2\n"), 0644))
+
+	resources := NewPolicyResources()
+	assert.NoError(t, resources.LoadAddressList(AddressList{Name: "treasury", File: jsonFile, Addresses: []string{"0x00000000000000000000000000000000000000aa"}}))
+	assert.NoError(t, resources.LoadAddressList(AddressList{Name: "exchanges", File: csvFile}))
+	assert.Error(t, resources.LoadAddressList(AddressList{Name: "missing", File: filepath.Join(dir, "missing.csv")}))
+	assert.Error(t, resources.RegisterAddressList("", nil))
+	traceParam := func(txInfo map[string]interface{}, param VerifyParams) ParamTrace {
+		return compileParam(param, resources).trace(txInfo)
+	}
+	checkParam := func(txInfo map[string]interface{}, param VerifyParams) bool {
+		return traceParam(txInfo, param).Passed
+	}
+
+	t.Run("EVM地址不区分大小写", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "to": "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "to", Value: "treasury", Rule: "inList"}))
+		assert.False(t, checkParam(txInfo, VerifyParams{Path: "to", Value: "treasury", Rule: "notInList"}))
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "to", Value: "exchanges", Rule: "notInList"}))
+
+		txInfo["to"] = "0x28c6c06298d514db089934071355e5743bf21d60"
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "to", Value: "treasury,exchanges", Rule: "inList"}))
+		trace := traceParam(txInfo, VerifyParams{Path: "to", Value: "treasury", Rule: "inList"})
+		assert.False(t, trace.Passed)
+		assert.Contains(t, trace.Reason, "not in list treasury")
+	})
+
+	t.Run("Solana地址精确匹配", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "Solana", "to": "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU"}
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "to", Value: "treasury", Rule: "inList"}))
+		txInfo["to"] = "7xkxtg2cw87d97txjsdpbd5jbkhetqa83tzrujosgasu"
+		assert.False(t, checkParam(txInfo, VerifyParams{Path: "to", Value: "treasury", Rule: "inList"}))
+	})
+
+	t.Run("Benfen地址补齐", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "Benfen", "to": "0x0000000000000000000000000000000000000000000000000000000000000002"}
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "to", Value: "exchanges", Rule: "inList"}))
+		addr, err := normalizeAddress("Benfen", "BFC00000000000000000000000000000000000000000000000000000000000000AB6e89")
+		assert.NoError(t, err)
+		assert.Equal(t, "0x00000000000000000000000000000000000000000000000000000000000000ab", addr)
+	})
+
+	t.Run("Benfen地址校验和错误", func(t *testing.T) {
+		_, err := normalizeAddress("Benfen", "BFC00000000000000000000000000000000000000000000000000000000000000AB1234")
+		assert.ErrorContains(t, err, "checksum mismatch")
+		assert.ErrorContains(t, resources.RegisterAddressList("bfc", []string{"BFC00000000000000000000000000000000000000000000000000000000000000AB1234"}), "checksum mismatch")
+
+		txInfo := map[string]interface{}{"chain": "Benfen", "to": "BFC00000000000000000000000000000000000000000000000000000000000000021234"}
+		trace := traceParam(txInfo, VerifyParams{Path: "to", Value: "exchanges", Rule: "notInList"})
+		assert.False(t, trace.Passed)
+		assert.Contains(t, trace.Reason, "checksum mismatch")
+	})
+
+	t.Run("空列表inList不通过", func(t *testing.T) {
+		resources := NewPolicyResources()
+		assert.NoError(t, resources.RegisterAddressList("empty", nil))
+		txInfo := map[string]interface{}{"chain": "ETH", "to": "0x1"}
+		trace := compileParam(VerifyParams{Path: "to", Value: "empty", Rule: "inList"}, resources).trace(txInfo)
+		assert.False(t, trace.Passed)
+		assert.Equal(t, "address list empty is empty", trace.Reason)
+		assert.True(t, compileParam(VerifyParams{Path: "to", Value: "empty", Rule: "notInList"}, resources).trace(txInfo).Passed)
+
+		params := []ApprovalParams{{VerifyParams: []VerifyParams{{Path: "to", Value: "empty", Rule: "inList"}}}}
+		assert.ErrorContains(t, CompilePolicyWithResources(params, resources).Validate(), "address list empty is empty")
+	})
+
+	t.Run("列表中所有地址", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "targets": []interface{}{"0x00000000000000000000000000000000000000AA", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}}
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "targets", Value: "treasury", Rule: "inList"}))
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "targets", Value: "exchanges", Rule: "notInList"}))
+		txInfo["targets"] = append(txInfo["targets"].([]interface{}), "0x2")
+		assert.False(t, checkParam(txInfo, VerifyParams{Path: "targets", Value: "treasury", Rule: "inList"}))
+		assert.False(t, checkParam(txInfo, VerifyParams{Path: "targets", Value: "exchanges", Rule: "notInList"}))
+	})
+
+	t.Run("列表不存在", func(t *testing.T) {
+		trace := traceParam(map[string]interface{}{"chain": "ETH", "to": "0x1"}, VerifyParams{Path: "to", Value: "unknown", Rule: "notInList"})
+		assert.False(t, trace.Passed)
+		assert.Equal(t, `address list "unknown" not found`, trace.Reason)
+	})
+
+	t.Run("只对注册的资源生效", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "to": "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}
+		trace := TraceParam(txInfo, VerifyParams{Path: "to", Value: "treasury", Rule: "inList"})
+		assert.False(t, trace.Passed)
+		assert.Equal(t, `address list "treasury" not found`, trace.Reason)
+	})
+}
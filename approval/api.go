@@ -0,0 +1,293 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/internal/apisign"
+	"github.com/google/uuid"
+)
+
+// 默认接口地址，使用apisdk.CompanyWalletClient的默认Host，apisdk固定使用https
+var defaultApiUrl = "https://" + apisdk.NewCompanyWalletClient("", "", 0).Host
+
+// Client依赖的openblock企业钱包接口，可以通过NewClientWithApi替换为自定义实现，如approvaltest.FakeApi
+// 实现同签名的GetApprovalsV2Notes方法时，ExportHistory导出发起的审批的备注
+type OpenBlockApi interface {
+	GetApprovals(ctx context.Context, params *apisdk.ParamGetApprovals) (*apisdk.RespApprovals, error)
+	GetApprovalsV2(ctx context.Context, params *apisdk.ParamGetApprovalsV2) (*apisdk.RespApprovalsV2, error)
+	AgreeApproval(ctx context.Context, params *apisdk.ParamAgreeApproval) (*apisdk.RespAgreeApproval, error)
+	NewApproval(ctx context.Context, params *apisdk.ParamNewApproval) (*apisdk.RespNewApproval, error)
+	GetCompanyWalletInfo(ctx context.Context) (*apisdk.RespGetCompanyWalletInfo, error)
+	GetCompanyWalletHDWalletAddress(ctx context.Context, params *apisdk.ParamGetCompanyWalletHDWalletAddress) (*apisdk.RespGetCompanyWalletHDWalletAddress, error)
+}
+
+// openblock企业钱包接口的http实现，与apisdk.CompanyWalletClient签名方式一致，支持context取消
+// 与apisdk不同，NewApproval会发送note参数，apisdk v0.0.4不发送备注
+type httpApi struct {
+	baseUrl    string
+	apiKey     string
+	apiSecret  string
+	httpClient *http.Client
+}
+
+func newHttpApi(apiKey, apiSecret string, timeout time.Duration) *httpApi {
+	return &httpApi{
+		baseUrl:    defaultApiUrl,
+		apiKey:     apiKey,
+		apiSecret:  apiSecret,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (a *httpApi) GetApprovals(ctx context.Context, params *apisdk.ParamGetApprovals) (*apisdk.RespApprovals, error) {
+	inparams := map[string]any{}
+	if params.Page != 0 {
+		inparams["page"] = params.Page
+	}
+	if params.Limit != 0 {
+		inparams["limit"] = params.Limit
+	}
+	if params.Status != "" {
+		inparams["status"] = params.Status
+	}
+
+	ret := &apisdk.RespApprovals{}
+	err := a.invoke(ctx, http.MethodGet, "/openapi/company_wallet/approvals/", inparams, ret)
+	return ret, err
+}
+
+func (a *httpApi) GetApprovalsV2(ctx context.Context, params *apisdk.ParamGetApprovalsV2) (*apisdk.RespApprovalsV2, error) {
+	ret := &apisdk.RespApprovalsV2{}
+	err := a.invoke(ctx, http.MethodGet, "/openapi/company_wallet/approvalsv2/", approvalsV2Params(params), ret)
+	return ret, err
+}
+
+// 同GetApprovalsV2，同时返回每条审批的备注，apisdk.RespApprovalsV2不包含note
+func (a *httpApi) GetApprovalsV2Notes(ctx context.Context, params *apisdk.ParamGetApprovalsV2) (*apisdk.RespApprovalsV2, map[string]string, error) {
+	ret := &approvalsV2WithNotes{resp: &apisdk.RespApprovalsV2{}, notes: map[string]string{}}
+	err := a.invoke(ctx, http.MethodGet, "/openapi/company_wallet/approvalsv2/", approvalsV2Params(params), ret)
+	return ret.resp, ret.notes, err
+}
+
+func approvalsV2Params(params *apisdk.ParamGetApprovalsV2) map[string]any {
+	inparams := map[string]any{}
+	if params.Page != 0 {
+		inparams["page"] = params.Page
+	}
+	if params.Limit != 0 {
+		inparams["limit"] = params.Limit
+	}
+	if params.ListType != "" {
+		inparams["list_type"] = params.ListType
+	}
+	if params.RecordID != "" {
+		inparams["record_id"] = params.RecordID
+	}
+	return inparams
+}
+
+// 解析GetApprovalsV2的响应，同时解析每条审批的note
+type approvalsV2WithNotes struct {
+	resp  *apisdk.RespApprovalsV2
+	notes map[string]string
+}
+
+func (r *approvalsV2WithNotes) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, r.resp); err != nil {
+		return err
+	}
+	var notes struct {
+		Data struct {
+			Data []struct {
+				RecordID string `json:"record_id"`
+				Note     string `json:"note"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return err
+	}
+	for _, item := range notes.Data.Data {
+		r.notes[item.RecordID] = item.Note
+	}
+	return nil
+}
+
+func (a *httpApi) AgreeApproval(ctx context.Context, params *apisdk.ParamAgreeApproval) (*apisdk.RespAgreeApproval, error) {
+	if params.RecordID == "" {
+		return nil, fmt.Errorf("RecordID is required")
+	}
+	if params.Agree == "" {
+		return nil, fmt.Errorf("Agree is required")
+	}
+	inparams := map[string]any{
+		"record_id": params.RecordID,
+		"agree":     params.Agree,
+	}
+
+	ret := &apisdk.RespAgreeApproval{}
+	err := a.invoke(ctx, http.MethodPost, "/openapi/company_wallet/approval/agree/", inparams, ret)
+	return ret, err
+}
+
+func (a *httpApi) NewApproval(ctx context.Context, params *apisdk.ParamNewApproval) (*apisdk.RespNewApproval, error) {
+	if params.Action == "" {
+		return nil, fmt.Errorf("action is required")
+	}
+	if params.TXInfo.Chain == "" {
+		return nil, fmt.Errorf("TXInfo.Chain is required")
+	}
+	inparams := map[string]any{
+		"action": params.Action,
+	}
+	if params.HDWalletID != "" {
+		inparams["hd_wallet_id"] = params.HDWalletID
+	}
+	if params.ExpiredTimeout != 0 {
+		inparams["expired_timeout"] = params.ExpiredTimeout
+	}
+	if params.Note != "" {
+		inparams["note"] = params.Note
+	}
+	txinfoString, err := json.Marshal(params.TXInfo)
+	if err != nil {
+		return nil, err
+	}
+	inparams["txinfo"] = string(txinfoString)
+
+	ret := &apisdk.RespNewApproval{}
+	err = a.invoke(ctx, http.MethodPost, "/openapi/company_wallet/approval/new/", inparams, ret)
+	return ret, err
+}
+
+func (a *httpApi) GetCompanyWalletInfo(ctx context.Context) (*apisdk.RespGetCompanyWalletInfo, error) {
+	ret := &apisdk.RespGetCompanyWalletInfo{}
+	err := a.invoke(ctx, http.MethodGet, "/openapi/company_wallet/info/", map[string]any{}, ret)
+	return ret, err
+}
+
+func (a *httpApi) GetCompanyWalletHDWalletAddress(ctx context.Context, params *apisdk.ParamGetCompanyWalletHDWalletAddress) (*apisdk.RespGetCompanyWalletHDWalletAddress, error) {
+	if params.HDWalletID == "" {
+		return nil, fmt.Errorf("HDWalletID is required")
+	}
+	inparams := map[string]any{
+		"hd_wallet_id": params.HDWalletID,
+	}
+
+	ret := &apisdk.RespGetCompanyWalletHDWalletAddress{}
+	err := a.invoke(ctx, http.MethodGet, "/openapi/company_wallet/hd_wallet_address/", inparams, ret)
+	return ret, err
+}
+
+func (a *httpApi) invoke(ctx context.Context, method, path string, params map[string]any, response any) error {
+	statusCode, body, err := a.doRequest(ctx, method, path, params)
+	if err != nil {
+		return err
+	}
+
+	// 检查是否返回错误
+	var bodyMap map[string]any
+	if err := json.Unmarshal(body, &bodyMap); err != nil {
+		if statusCode != http.StatusOK {
+			return classifyStatus(statusCode, strings.TrimSpace(string(body)))
+		}
+		return &ApiError{Kind: ErrorTransient, StatusCode: statusCode, Err: fmt.Errorf("invalid response: %w", err)}
+	}
+	if _, ok := bodyMap["err_code"]; ok {
+		var retErr apisdk.RespError
+		if err := json.Unmarshal(body, &retErr); err != nil {
+			// 无法解析错误信息，不能确定请求是否生效
+			return &ApiError{Kind: ErrorTransient, StatusCode: statusCode, Err: fmt.Errorf("invalid error response: %w", err)}
+		}
+		if statusCode != http.StatusOK {
+			apiErr := classifyStatus(statusCode, retErr.ErrMsg)
+			apiErr.Code = fmt.Sprintf("%v", retErr.ErrCode)
+			return apiErr
+		}
+		return classifyErrCode(fmt.Sprintf("%v", retErr.ErrCode), retErr.ErrMsg)
+	}
+	if statusCode != http.StatusOK {
+		return classifyStatus(statusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, response); err != nil {
+		// 请求已成功但响应无法解析，如发起审批时审批可能已经创建
+		return &ApiError{Kind: ErrorTransient, StatusCode: statusCode, Err: fmt.Errorf("invalid response: %w", err)}
+	}
+	return nil
+}
+
+// 返回http状态码和响应内容
+func (a *httpApi) doRequest(ctx context.Context, method, path string, params map[string]any) (int, []byte, error) {
+	u, err := url.Parse(a.baseUrl)
+	if err != nil {
+		return 0, nil, err
+	}
+	u.Path = path
+	params = a.signParams(params)
+
+	var req *http.Request
+	switch method {
+	case http.MethodGet:
+		values := url.Values{}
+		for k, v := range params {
+			values.Add(k, fmt.Sprintf("%v", v))
+		}
+		u.RawQuery = values.Encode()
+		req, err = http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return 0, nil, err
+		}
+	case http.MethodPost:
+		jsonBody, err := json.Marshal(params)
+		if err != nil {
+			return 0, nil, err
+		}
+		req, err = http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(jsonBody))
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	default:
+		return 0, nil, fmt.Errorf("invalid method: %s", method)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		return 0, nil, &ApiError{Kind: ErrorTransient, Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, &ApiError{Kind: ErrorTransient, StatusCode: resp.StatusCode, Err: err}
+	}
+	return resp.StatusCode, body, nil
+}
+
+// 添加nonce、sign、api_key参数
+func (a *httpApi) signParams(params map[string]any) map[string]any {
+	ret := make(map[string]any, len(params)+3)
+	for k, v := range params {
+		ret[k] = v
+	}
+	ret["nonce"] = strings.ToUpper(uuid.New().String())
+	ret["sign"] = a.sign(ret)
+	ret["api_key"] = a.apiKey
+	return ret
+}
+
+// apisdk没有导出签名方法，api_test.go中与apisdk实际发出的请求对比签名
+func (a *httpApi) sign(params map[string]any) string {
+	return apisign.Sign(params, a.apiKey, a.apiSecret)
+}
@@ -0,0 +1,142 @@
+package approval
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpApi(t *testing.T) {
+	t.Run("签名参数", func(t *testing.T) {
+		var query map[string][]string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Write([]byte(`{"page": 1, "limit": 20, "data": [{"record_id": "r1", "status": "ING"}]}`))
+		}))
+		defer server.Close()
+
+		api := newHttpApi("key", "secret", time.Second)
+		api.baseUrl = server.URL
+		resp, err := api.GetApprovals(context.Background(), &apisdk.ParamGetApprovals{Page: 1, Limit: 20, Status: "ING"})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Data, 1)
+		assert.Equal(t, "r1", resp.Data[0].RecordId)
+
+		// 与apisdk相同的签名算法: 参数按key排序拼接后HMAC-SHA256
+		assert.Equal(t, "key", query["api_key"][0])
+		signStr := fmt.Sprintf("limit=20&nonce=%s&page=1&status=ING&api_key=key", query["nonce"][0])
+		h := hmac.New(sha256.New, []byte("secret"))
+		h.Write([]byte(signStr))
+		assert.Equal(t, strings.ToUpper(hex.EncodeToString(h.Sum(nil))), query["sign"][0])
+	})
+
+	t.Run("返回错误码", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"ok": false, "err_code": "40001", "err_msg": "invalid sign"}`))
+		}))
+		defer server.Close()
+
+		api := newHttpApi("key", "secret", time.Second)
+		api.baseUrl = server.URL
+		_, err := api.AgreeApproval(context.Background(), &apisdk.ParamAgreeApproval{RecordID: "r1", Agree: "agree"})
+		assert.ErrorContains(t, err, "invalid sign")
+	})
+
+	t.Run("响应无法解析", func(t *testing.T) {
+		for _, body := range []string{`{"ok": "yes", "data": {}}`, `{"err_code": {}, "err_msg": 1}`} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body))
+			}))
+			api := newHttpApi("key", "secret", time.Second)
+			api.baseUrl = server.URL
+			_, err := api.NewApproval(context.Background(), &apisdk.ParamNewApproval{Action: "TRANSACTION", TXInfo: apisdk.TXInfo{Chain: "ETH"}})
+			server.Close()
+			assert.Equal(t, ErrorTransient, ErrorKindOf(err), body)
+			assert.ErrorContains(t, err, "invalid", body)
+		}
+	})
+
+	t.Run("context取消", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		api := newHttpApi("key", "secret", 10*time.Second)
+		api.baseUrl = server.URL
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := api.GetCompanyWalletInfo(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
+
+func TestHttpApiSignMatchesApisdk(t *testing.T) {
+	// 记录apisdk实际发出的请求参数
+	var params map[string]any
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = map[string]any{}
+		if r.Method == http.MethodGet {
+			for k, v := range r.URL.Query() {
+				params[k] = v[0]
+			}
+		} else {
+			json.NewDecoder(r.Body).Decode(&params)
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	// apisdk固定使用https和默认的http.Transport，测试时替换为信任测试证书的Transport
+	transport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	defer func() { http.DefaultTransport = transport }()
+	u, _ := url.Parse(server.URL)
+	sdk := apisdk.NewCompanyWalletClient("key", "secret", time.Second)
+	sdk.Host = u.Host
+	api := newHttpApi("key", "secret", time.Second)
+
+	checkSign := func(t *testing.T) {
+		assert.Equal(t, "key", params["api_key"])
+		sign := params["sign"]
+		delete(params, "sign")
+		delete(params, "api_key")
+		assert.Equal(t, sign, api.sign(params))
+		params = nil
+	}
+
+	t.Run("GET请求", func(t *testing.T) {
+		sdk.GetApprovals(&apisdk.ParamGetApprovals{Page: 2, Limit: 20, Status: "ING"})
+		checkSign(t)
+	})
+
+	t.Run("POST请求", func(t *testing.T) {
+		sdk.NewApproval(&apisdk.ParamNewApproval{
+			HDWalletID:     "hd1",
+			Action:         "TRANSACTION",
+			TXInfo:         apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"},
+			Note:           "INV-1",
+			ExpiredTimeout: 600,
+		})
+		assert.Contains(t, params, "txinfo")
+		checkSign(t)
+	})
+
+	t.Run("默认接口地址", func(t *testing.T) {
+		assert.Equal(t, "https://"+apisdk.NewCompanyWalletClient("", "", 0).Host, newHttpApi("", "", 0).baseUrl)
+	})
+}
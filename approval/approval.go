@@ -1,20 +1,42 @@
 package approval
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"sync"
 
 	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
 )
 
 type ApprovalWallet struct {
-	Role           string
-	ApiKey         string
-	ApiSecret      string
-	DockerPort     string
-	ApprovalParams []ApprovalParams
-	TxInfo         *apisdk.TXInfo
-	Client         *Client
+	Role       string
+	ApiKey     string
+	ApiSecret  string
+	ApiUrl     string
+	DockerPort string
+	StorePath  string
+	DryRun     bool
+	PageSize   int
+	// 待签名记录的最大签名尝试次数，0为一直重试
+	MaxSignAttempts int
+	AbiFiles        []string      // 合约ABI文件，用于解码EVM calldata
+	Tokens          []Token       // token精度，用于带单位的规则值，如 1000 USDC
+	AddressLists    []AddressList // 命名地址列表，用于inList/notInList规则
+	PolicyFile      string        // 单独的审批规则文件，包含approvalParams，为空时使用配置文件中的approvalParams
+	ApprovalParams  []ApprovalParams
+	TxInfo          *apisdk.TXInfo
+	ApprovalOptions ApprovalOptions // initiator发起审批的备注、过期时间等
+	Client          *Client
+
+	policyMu   sync.Mutex
+	policy     *Policy          // ApprovalParams编译后的规则，SetApprovalParams和ReloadPolicy时更新
+	policyHash [32]byte         // 最后一次加载的配置和规则、ABI、地址列表文件内容的hash，文件未修改时不重新加载
+	resources  *PolicyResources // AbiFiles等配置加载后的资源，编译规则时使用
 }
 
 /*
@@ -37,8 +59,13 @@ func NewApprovalWallet(apiKey, apiSecret string) *ApprovalWallet {
     @txData: SOL base64交易
     返回值: txHash
 */
-func (w *ApprovalWallet) SendApprovalTransaction(hdWalletId, chainName, txData string) (string, error) {
-	return SendApprovalTransaction(w.Client, hdWalletId, chainName, txData)
+func (w *ApprovalWallet) SendApprovalTransaction(hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
+	return w.SendApprovalTransactionContext(context.Background(), hdWalletId, chainName, txData, opts...)
+}
+
+// 同SendApprovalTransaction，ctx取消或超时时停止等待审批结果
+func (w *ApprovalWallet) SendApprovalTransactionContext(ctx context.Context, hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
+	return SendApprovalTransactionContext(ctx, w.Client, hdWalletId, chainName, txData, opts...)
 }
 
 /*
@@ -47,8 +74,13 @@ func (w *ApprovalWallet) SendApprovalTransaction(hdWalletId, chainName, txData s
     @txData: SOL base64交易
     返回值: txHash
 */
-func (w *ApprovalWallet) SignApprovalTransaction(hdWalletId, chainName, txData string) (string, error) {
-	return SignApprovalTransaction(w.Client, hdWalletId, chainName, txData)
+func (w *ApprovalWallet) SignApprovalTransaction(hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
+	return w.SignApprovalTransactionContext(context.Background(), hdWalletId, chainName, txData, opts...)
+}
+
+// 同SignApprovalTransaction，ctx取消或超时时停止等待审批结果
+func (w *ApprovalWallet) SignApprovalTransactionContext(ctx context.Context, hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
+	return SignApprovalTransactionContext(ctx, w.Client, hdWalletId, chainName, txData, opts...)
 }
 
 /*
@@ -57,8 +89,83 @@ func (w *ApprovalWallet) SignApprovalTransaction(hdWalletId, chainName, txData s
     @txInfo: 参考ob api接口
     返回值: txHash/签名
 */
-func (w *ApprovalWallet) SendApprovalTxInfo(hdWalletId string, txInfo *apisdk.TXInfo) (string, error) {
-	return SendApprovalTxInfo(w.Client, hdWalletId, txInfo)
+func (w *ApprovalWallet) SendApprovalTxInfo(hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	return w.SendApprovalTxInfoContext(context.Background(), hdWalletId, txInfo, opts...)
+}
+
+// 同SendApprovalTxInfo，ctx取消或超时时停止等待审批结果
+func (w *ApprovalWallet) SendApprovalTxInfoContext(ctx context.Context, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	return SendApprovalTxInfoContext(ctx, w.Client, hdWalletId, txInfo, opts...)
+}
+
+/*
+  - 发起审批，不等待审批结果
+    返回值: 审批句柄，可以保存后通过WaitApproval继续等待
+*/
+func (w *ApprovalWallet) SubmitApprovalTxInfo(hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	return w.SubmitApprovalTxInfoContext(context.Background(), hdWalletId, txInfo, opts...)
+}
+
+func (w *ApprovalWallet) SubmitApprovalTxInfoContext(ctx context.Context, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	return SubmitApprovalTxInfoContext(ctx, w.Client, hdWalletId, txInfo, opts...)
+}
+
+/*
+  - 使用幂等key发起审批，同一个key再次调用时返回已发起的审批，不重复发起
+    配置storePath时key和recordId持久化到本地，进程重启后仍然有效
+*/
+func (w *ApprovalWallet) SubmitApprovalTxInfoWithKey(key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	return w.SubmitApprovalTxInfoWithKeyContext(context.Background(), key, hdWalletId, txInfo, opts...)
+}
+
+func (w *ApprovalWallet) SubmitApprovalTxInfoWithKeyContext(ctx context.Context, key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	return SubmitApprovalTxInfoWithKeyContext(ctx, w.Client, key, hdWalletId, txInfo, opts...)
+}
+
+// 使用幂等key发起审批并等待审批结果，同一个key再次调用时继续等待已发起的审批
+func (w *ApprovalWallet) SendApprovalTxInfoWithKey(key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	return w.SendApprovalTxInfoWithKeyContext(context.Background(), key, hdWalletId, txInfo, opts...)
+}
+
+func (w *ApprovalWallet) SendApprovalTxInfoWithKeyContext(ctx context.Context, key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	return SendApprovalTxInfoWithKeyContext(ctx, w.Client, key, hdWalletId, txInfo, opts...)
+}
+
+/*
+  - 等待已发起的审批结果，查询间隔和超时时间使用Client.WaitOptions
+    返回值: txHash/签名
+*/
+func (w *ApprovalWallet) WaitApproval(handle *ApprovalHandle) (string, error) {
+	return w.WaitApprovalContext(context.Background(), handle)
+}
+
+// 同WaitApproval，ctx取消时停止等待
+func (w *ApprovalWallet) WaitApprovalContext(ctx context.Context, handle *ApprovalHandle) (string, error) {
+	return WaitApprovalContext(ctx, w.Client, handle, w.Client.WaitOptions)
+}
+
+// 查询已发起的审批状态
+func (w *ApprovalWallet) GetApprovalStatus(handle *ApprovalHandle) (*ApprovalStatus, error) {
+	return w.GetApprovalStatusContext(context.Background(), handle)
+}
+
+func (w *ApprovalWallet) GetApprovalStatusContext(ctx context.Context, handle *ApprovalHandle) (*ApprovalStatus, error) {
+	return GetApprovalStatusContext(ctx, w.Client, handle)
+}
+
+/*
+  - 批量发起审批并等待审批结果，opts.Wait为空时使用Client.WaitOptions
+    返回值: 每笔审批的结果，顺序与items一致
+*/
+func (w *ApprovalWallet) SendBatch(items []BatchItem, opts BatchOptions) ([]BatchResult, error) {
+	return w.SendBatchContext(context.Background(), items, opts)
+}
+
+func (w *ApprovalWallet) SendBatchContext(ctx context.Context, items []BatchItem, opts BatchOptions) ([]BatchResult, error) {
+	if opts.Wait == (WaitOptions{}) {
+		opts.Wait = w.Client.WaitOptions
+	}
+	return SendBatchContext(ctx, w.Client, items, opts)
 }
 
 /*
@@ -67,24 +174,240 @@ func (w *ApprovalWallet) SendApprovalTxInfo(hdWalletId string, txInfo *apisdk.TX
     @message: SOL hex格式消息
     返回值: 签名
 */
-func (w *ApprovalWallet) SignApprovalMessage(hdWalletId, chainName, message string) (string, error) {
-	return SignApprovalMessage(w.Client, hdWalletId, chainName, message)
+func (w *ApprovalWallet) SignApprovalMessage(hdWalletId, chainName, message string, opts ...ApprovalOptions) (string, error) {
+	return w.SignApprovalMessageContext(context.Background(), hdWalletId, chainName, message, opts...)
+}
+
+// 同SignApprovalMessage，ctx取消或超时时停止等待审批结果
+func (w *ApprovalWallet) SignApprovalMessageContext(ctx context.Context, hdWalletId, chainName, message string, opts ...ApprovalOptions) (string, error) {
+	return SignApprovalMessageContext(ctx, w.Client, hdWalletId, chainName, message, opts...)
 }
 
 /*
 - 自动审批交易
 */
 func (w *ApprovalWallet) AutoApprove() error {
-	_, err := AutoApprove(w.Client, &w.ApprovalParams)
+	return w.AutoApproveContext(context.Background())
+}
+
+// 同AutoApprove，ctx取消时停止处理剩余审批
+func (w *ApprovalWallet) AutoApproveContext(ctx context.Context) error {
+	_, err := AutoApprovePolicyContext(ctx, w.Client, w.Policy())
 	return err
 }
 
 /*
-  - 签名交易
-    返回值: txHash
+- 自动审批并调用docker签名，签名失败的记录在后续调用时重试
 */
 func (w *ApprovalWallet) AutoSign() error {
-	return AutoSign(w.Client, &w.ApprovalParams, w.DockerPort)
+	return w.AutoSignContext(context.Background())
+}
+
+// 同AutoSign，ctx取消时停止处理剩余审批和签名
+func (w *ApprovalWallet) AutoSignContext(ctx context.Context) error {
+	_, err := AutoSignPolicyContext(ctx, w.Client, w.Policy(), w.DockerPort)
+	return err
+}
+
+// 编译后的审批规则，第一次调用时编译ApprovalParams，之后修改ApprovalParams需要调用SetApprovalParams重新编译
+func (w *ApprovalWallet) Policy() *Policy {
+	w.policyMu.Lock()
+	defer w.policyMu.Unlock()
+	if w.policy == nil {
+		w.policy = CompilePolicyWithResources(w.ApprovalParams, w.resources)
+	}
+	return w.policy
+}
+
+// 设置审批规则并重新编译，直接修改ApprovalParams后也通过SetApprovalParams(w.ApprovalParams)生效
+func (w *ApprovalWallet) SetApprovalParams(params []ApprovalParams) {
+	w.policyMu.Lock()
+	defer w.policyMu.Unlock()
+	w.ApprovalParams = params
+	w.policy = CompilePolicyWithResources(params, w.resources)
+}
+
+/*
+  - 从配置文件重新加载审批规则，同时重新加载配置中的abiFiles、tokens、addressLists和policyFile
+    校验通过后替换当前规则，正在进行的审批继续使用原来的规则，校验失败时保留原来的规则
+    @path: 配置文件路径，配置中的相对路径相对于配置文件所在目录
+    返回值: 规则是否变更，配置和引用的文件内容都未修改时返回false且不重复校验
+*/
+func (w *ApprovalWallet) ReloadPolicy(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	config, err := parsePolicyConfig(path, data)
+	if err != nil {
+		return false, err
+	}
+	hash, err := config.hash(data)
+	if err != nil {
+		return false, err
+	}
+
+	w.policyMu.Lock()
+	defer w.policyMu.Unlock()
+	if hash == w.policyHash {
+		return false, nil
+	}
+	w.policyHash = hash
+	policy, resources, err := config.load(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if w.policy != nil && w.policy.Version == policy.Version {
+		return false, nil
+	}
+	w.AbiFiles, w.Tokens, w.AddressLists, w.PolicyFile = config.AbiFiles, config.Tokens, config.AddressLists, config.PolicyFile
+	w.resources = resources
+	w.ApprovalParams = cloneParams(policy.Params) // 钱包持有单独的副本，修改不影响编译后的规则
+	w.policy = policy
+	return true, nil
+}
+
+/*
+  - 从配置文件加载审批规则，配置中的abiFiles、tokens、addressLists只用于返回的规则
+    配置了policyFile时从policyFile中加载approvalParams，相对路径相对于配置文件所在目录
+*/
+func LoadPolicyFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parsePolicyConfig(path, data)
+	if err != nil {
+		return nil, err
+	}
+	policy, _, err := config.load(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// 配置文件中审批规则用到的配置
+type policyConfig struct {
+	AbiFiles     []string
+	Tokens       []Token
+	AddressLists []AddressList
+	PolicyFile   string
+}
+
+// 解析配置文件中审批规则用到的配置，相对路径转换为相对于配置文件所在目录的路径
+func parsePolicyConfig(path string, data []byte) (policyConfig, error) {
+	var config policyConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	config.PolicyFile = resolvePath(dir, config.PolicyFile)
+	for i := range config.AbiFiles {
+		config.AbiFiles[i] = resolvePath(dir, config.AbiFiles[i])
+	}
+	for i := range config.AddressLists {
+		config.AddressLists[i].File = resolvePath(dir, config.AddressLists[i].File)
+	}
+	return config, nil
+}
+
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// 加载配置中的资源和审批规则，data为配置文件内容
+func (c policyConfig) load(data []byte) (*Policy, *PolicyResources, error) {
+	resources, err := registerPolicyResources(c.AbiFiles, c.Tokens, c.AddressLists)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := loadPolicy(data, c.PolicyFile, resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	return policy, resources, nil
+}
+
+// 配置文件以及policyFile、abiFiles、地址列表文件内容的hash，用于判断是否需要重新加载
+func (c policyConfig) hash(data []byte) ([32]byte, error) {
+	files := append([]string{c.PolicyFile}, c.AbiFiles...)
+	for _, list := range c.AddressLists {
+		files = append(files, list.File)
+	}
+	h := sha256.New()
+	h.Write(data)
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		fileData, err := os.ReadFile(file)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		h.Write([]byte{0})
+		h.Write(fileData)
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// 加载审批规则中用到的ABI、token和地址列表，需要在校验规则前加载
+func registerPolicyResources(abiFiles []string, tokens []Token, lists []AddressList) (*PolicyResources, error) {
+	resources := NewPolicyResources()
+	for _, path := range abiFiles {
+		if err := resources.RegisterABIFile(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, token := range tokens {
+		if err := resources.RegisterToken(token); err != nil {
+			return nil, err
+		}
+	}
+	for _, list := range lists {
+		if err := resources.LoadAddressList(list); err != nil {
+			return nil, err
+		}
+	}
+	return resources, nil
+}
+
+// 加载配置中的approvalParams，policyFile不为空时从policyFile中加载
+func loadPolicy(data []byte, policyFile string, resources *PolicyResources) (*Policy, error) {
+	if policyFile == "" {
+		return parsePolicy(data, resources)
+	}
+	data, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := parsePolicy(data, resources)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", policyFile, err)
+	}
+	return policy, nil
+}
+
+// 解析并校验json中的approvalParams，错误中包含行号
+func parsePolicy(data []byte, resources *PolicyResources) (*Policy, error) {
+	var config struct {
+		ApprovalParams []ApprovalParams
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if errs := validateApprovalParams(config.ApprovalParams, resources); len(errs) > 0 {
+		return nil, errs.withLines(data)
+	}
+	for _, warning := range policyWarnings(config.ApprovalParams).withLines(data) {
+		log.Printf("policy warning: %s", warning)
+	}
+	return CompilePolicyWithResources(config.ApprovalParams, resources), nil
 }
 
 func NewApprovalWalletFromJson(filePath string) (*ApprovalWallet, error) {
@@ -99,6 +422,36 @@ func NewApprovalWalletFromJson(filePath string) (*ApprovalWallet, error) {
 		return nil, err
 	}
 	w.Client = NewClient(w.ApiKey, w.ApiSecret)
+	if w.ApiUrl != "" {
+		w.Client.SetApiUrl(w.ApiUrl)
+	}
+	w.Client.DryRun = w.DryRun
+	w.Client.PageSize = w.PageSize
+	w.Client.MaxSignAttempts = w.MaxSignAttempts
+	config, err := parsePolicyConfig(filePath, data)
+	if err != nil {
+		return nil, err
+	}
+	// 启动前校验所有审批规则，错误中包含配置文件行号
+	policy, resources, err := config.load(data)
+	if err != nil {
+		return nil, err
+	}
+	if w.policyHash, err = config.hash(data); err != nil {
+		return nil, err
+	}
+	w.AbiFiles, w.Tokens, w.AddressLists, w.PolicyFile = config.AbiFiles, config.Tokens, config.AddressLists, config.PolicyFile
+	w.resources = resources
+	w.ApprovalParams = cloneParams(policy.Params)
+	w.policy = policy
+	if w.StorePath != "" {
+		w.StorePath = resolvePath(filepath.Dir(filePath), w.StorePath)
+		store, err := NewStore(w.StorePath)
+		if err != nil {
+			return nil, err
+		}
+		w.Client.Store = store
+	}
 	if w.DockerPort == "" {
 		w.DockerPort = "7790"
 	}
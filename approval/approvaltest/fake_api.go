@@ -0,0 +1,450 @@
+// Package approvaltest 提供openblock企业钱包接口的测试实现
+package approvaltest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+)
+
+// 审批状态
+const (
+	StatusIng    = "ING"
+	StatusAgree  = "AGREE"
+	StatusReject = "REJECT"
+)
+
+// 内存中的审批记录
+type Record struct {
+	RecordId       string
+	HDWalletId     string
+	Action         string
+	TxInfo         apisdk.TXInfo
+	Note           string
+	ExpiredTimeout int32
+	Status         string
+	Agrees         int
+	Rejects        int
+	Sponsored      bool // 由当前api key发起
+	TxHash         string
+	CustomData     string
+	Authorization  *apisdk.Authorization
+	CreateTime     time.Time
+}
+
+// HD钱包
+type HDWallet struct {
+	WalletName string
+	Addresses  map[string]string // chain -> address
+}
+
+// 内存中模拟的openblock企业钱包接口，实现approval.OpenBlockApi
+// 审批状态流转: NewApproval/AddApproval创建ING状态的审批，同意次数达到RequiredAgrees后变为AGREE，任一拒绝变为REJECT
+type FakeApi struct {
+	mu sync.Mutex
+
+	CompanyWalletId string
+	WalletName      string
+	Addresses       map[string]string // 主钱包地址 chain -> address
+	HDWallets       map[string]*HDWallet
+	RequiredAgrees  int  // 审批通过需要的同意次数，默认1
+	AutoAgree       bool // 发起的审批自动同意通过
+	MaxPageSize     int  // 每页最大数量，请求的limit超过时按最大数量返回，模拟服务端限制，0为不限制
+
+	// 审批状态变化时回调，可以用于设置签名结果等，回调时持有锁，只能直接修改r，不能调用FakeApi的方法
+	OnNewApproval func(r *Record)
+	OnAgree       func(r *Record)
+
+	records []*Record
+	nextId  int
+	calls   map[string]int
+}
+
+func NewFakeApi() *FakeApi {
+	return &FakeApi{
+		CompanyWalletId: "company-wallet",
+		WalletName:      "Company Wallet",
+		Addresses:       map[string]string{},
+		HDWallets:       map[string]*HDWallet{},
+		RequiredAgrees:  1,
+		calls:           map[string]int{},
+	}
+}
+
+// 添加一个由其他人发起的待审批记录，返回recordId
+func (f *FakeApi) AddApproval(hdWalletId, action string, txInfo apisdk.TXInfo) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addRecord(hdWalletId, action, txInfo, "", 0, false).RecordId
+}
+
+// 模拟其他审批人同意
+func (f *FakeApi) Agree(recordId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.agree(recordId, true)
+}
+
+// 模拟其他审批人拒绝
+func (f *FakeApi) Reject(recordId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.agree(recordId, false)
+}
+
+// 查询审批记录的副本
+func (f *FakeApi) Record(recordId string) (Record, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r := f.find(recordId); r != nil {
+		return *r, true
+	}
+	return Record{}, false
+}
+
+// 修改审批记录，如设置CustomData、Authorization等签名结果
+func (f *FakeApi) Update(recordId string, update func(r *Record)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.find(recordId)
+	if r == nil {
+		return fmt.Errorf("record %s not found", recordId)
+	}
+	update(r)
+	return nil
+}
+
+// 接口调用次数，key为方法名，如AgreeApproval
+func (f *FakeApi) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *FakeApi) GetApprovals(ctx context.Context, params *apisdk.ParamGetApprovals) (*apisdk.RespApprovals, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetApprovals"]++
+
+	var records []*Record
+	for _, r := range f.records {
+		if params.Status == "" || r.Status == params.Status {
+			records = append(records, r)
+		}
+	}
+	page, limit := f.pageParams(params.Page, params.Limit)
+	var data []map[string]any
+	for _, r := range paginate(records, page, limit) {
+		data = append(data, recordJson(r))
+	}
+
+	ret := &apisdk.RespApprovals{}
+	err := convert(map[string]any{"page": page, "limit": limit, "data": data}, ret)
+	return ret, err
+}
+
+func (f *FakeApi) GetApprovalsV2(ctx context.Context, params *apisdk.ParamGetApprovalsV2) (*apisdk.RespApprovalsV2, error) {
+	ret := &apisdk.RespApprovalsV2{}
+	err := convert(f.approvalsV2(params), ret)
+	return ret, err
+}
+
+// 同GetApprovalsV2，同时返回每条审批的备注，apisdk.RespApprovalsV2不包含note
+func (f *FakeApi) GetApprovalsV2Notes(ctx context.Context, params *apisdk.ParamGetApprovalsV2) (*apisdk.RespApprovalsV2, map[string]string, error) {
+	data := f.approvalsV2(params)
+	ret := &apisdk.RespApprovalsV2{}
+	if err := convert(data, ret); err != nil {
+		return nil, nil, err
+	}
+	notes := map[string]string{}
+	for _, item := range data["data"].(map[string]any)["data"].([]map[string]any) {
+		notes[item["record_id"].(string)] = item["note"].(string)
+	}
+	return ret, notes, nil
+}
+
+// GetApprovalsV2的响应内容，包含note
+func (f *FakeApi) approvalsV2(params *apisdk.ParamGetApprovalsV2) map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetApprovalsV2"]++
+
+	var records []*Record
+	ingCount, sponsorCount, finishCount := 0, 0, 0
+	for i := len(f.records) - 1; i >= 0; i-- { //与服务端一致，按创建时间倒序
+		r := f.records[i]
+		if r.Status == StatusIng {
+			ingCount++
+		} else {
+			finishCount++
+		}
+		if r.Sponsored {
+			sponsorCount++
+		}
+		if params.ListType == "sponsor" && !r.Sponsored {
+			continue
+		}
+		if params.RecordID != "" && r.RecordId != params.RecordID {
+			continue
+		}
+		records = append(records, r)
+	}
+	page, limit := f.pageParams(params.Page, params.Limit)
+	var data []map[string]any
+	for _, r := range paginate(records, page, limit) {
+		item := recordJson(r)
+		item["expired_time"] = ""
+		if r.ExpiredTimeout > 0 {
+			item["expired_time"] = r.CreateTime.Add(time.Duration(r.ExpiredTimeout) * time.Second).Format(time.DateTime)
+		}
+		data = append(data, item)
+	}
+
+	return map[string]any{
+		"ok": true,
+		"data": map[string]any{
+			"page":          page,
+			"limit":         limit,
+			"ing_count":     ingCount,
+			"sponsor_count": sponsorCount,
+			"finish_count":  finishCount,
+			"data":          data,
+		},
+	}
+}
+
+func (f *FakeApi) AgreeApproval(ctx context.Context, params *apisdk.ParamAgreeApproval) (*apisdk.RespAgreeApproval, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["AgreeApproval"]++
+
+	if err := f.agree(params.RecordID, params.Agree == "agree"); err != nil {
+		return nil, err
+	}
+	r := f.find(params.RecordID)
+	ret := &apisdk.RespAgreeApproval{Ok: true}
+	ret.Data.RecordId = r.RecordId
+	ret.Data.OriginRecordId = r.RecordId
+	ret.Data.Status = r.Status
+	return ret, nil
+}
+
+func (f *FakeApi) NewApproval(ctx context.Context, params *apisdk.ParamNewApproval) (*apisdk.RespNewApproval, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["NewApproval"]++
+
+	if params.Action == "" {
+		return nil, fmt.Errorf("action is required")
+	}
+	if params.TXInfo.Chain == "" {
+		return nil, fmt.Errorf("TXInfo.Chain is required")
+	}
+	r := f.addRecord(params.HDWalletID, params.Action, params.TXInfo, params.Note, params.ExpiredTimeout, true)
+	if f.OnNewApproval != nil {
+		f.OnNewApproval(r)
+	}
+	for f.AutoAgree && r.Status == StatusIng {
+		if err := f.agree(r.RecordId, true); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := &apisdk.RespNewApproval{Ok: true}
+	ret.Data.OriginRecordId = r.RecordId
+	ret.Data.RecordId = r.RecordId
+	ret.Data.IsAutoPass = r.Status == StatusAgree
+	return ret, nil
+}
+
+func (f *FakeApi) GetCompanyWalletInfo(ctx context.Context) (*apisdk.RespGetCompanyWalletInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetCompanyWalletInfo"]++
+
+	ids := make([]string, 0, len(f.HDWallets))
+	for id := range f.HDWallets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var hdWallets []map[string]any
+	for _, id := range ids {
+		hdWallets = append(hdWallets, map[string]any{"hd_wallet_id": id, "wallet_name": f.HDWallets[id].WalletName})
+	}
+
+	ret := &apisdk.RespGetCompanyWalletInfo{}
+	err := convert(map[string]any{
+		"ok": true,
+		"data": map[string]any{
+			"company_wallet_info": map[string]any{
+				"company_wallet_id": f.CompanyWalletId,
+				"wallet_name":       f.WalletName,
+			},
+			"address_list":   addressList(f.Addresses),
+			"hd_wallet_list": hdWallets,
+		},
+	}, ret)
+	return ret, err
+}
+
+func (f *FakeApi) GetCompanyWalletHDWalletAddress(ctx context.Context, params *apisdk.ParamGetCompanyWalletHDWalletAddress) (*apisdk.RespGetCompanyWalletHDWalletAddress, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetCompanyWalletHDWalletAddress"]++
+
+	hdWallet, ok := f.HDWallets[params.HDWalletID]
+	if !ok {
+		return nil, fmt.Errorf("hd wallet %s not found", params.HDWalletID)
+	}
+	ret := &apisdk.RespGetCompanyWalletHDWalletAddress{}
+	err := convert(map[string]any{
+		"ok":   true,
+		"Data": map[string]any{"address_list": addressList(hdWallet.Addresses)},
+	}, ret)
+	return ret, err
+}
+
+// 调用方需持有锁
+func (f *FakeApi) addRecord(hdWalletId, action string, txInfo apisdk.TXInfo, note string, expiredTimeout int32, sponsored bool) *Record {
+	f.nextId++
+	r := &Record{
+		RecordId:       fmt.Sprintf("record-%d", f.nextId),
+		HDWalletId:     hdWalletId,
+		Action:         action,
+		TxInfo:         txInfo,
+		Note:           note,
+		ExpiredTimeout: expiredTimeout,
+		Status:         StatusIng,
+		Sponsored:      sponsored,
+		CreateTime:     time.Now(),
+	}
+	f.records = append(f.records, r)
+	return r
+}
+
+// 调用方需持有锁
+func (f *FakeApi) agree(recordId string, agree bool) error {
+	r := f.find(recordId)
+	if r == nil {
+		return fmt.Errorf("record %s not found", recordId)
+	}
+	if r.Status != StatusIng {
+		return fmt.Errorf("record %s is not pending, status: %s", recordId, r.Status)
+	}
+	if !agree {
+		r.Rejects++
+		r.Status = StatusReject
+		return nil
+	}
+
+	r.Agrees++
+	required := f.RequiredAgrees
+	if required <= 0 {
+		required = 1
+	}
+	if r.Agrees >= required {
+		r.Status = StatusAgree
+		if r.TxHash == "" {
+			hash := sha256.Sum256([]byte(r.RecordId))
+			r.TxHash = "0x" + hex.EncodeToString(hash[:])
+		}
+		if f.OnAgree != nil {
+			f.OnAgree(r)
+		}
+	}
+	return nil
+}
+
+// 调用方需持有锁
+func (f *FakeApi) find(recordId string) *Record {
+	for _, r := range f.records {
+		if r.RecordId == recordId {
+			return r
+		}
+	}
+	return nil
+}
+
+// 审批记录的接口返回格式
+func recordJson(r *Record) map[string]any {
+	txInfo := map[string]any{}
+	convert(r.TxInfo, &txInfo)
+	if _, ok := txInfo["amount"]; !ok && r.TxInfo.Value != "" { //服务端返回的txinfo包含amount
+		txInfo["amount"] = r.TxInfo.Value
+	}
+	myStatus := StatusIng
+	if r.Status != StatusIng {
+		myStatus = r.Status
+	}
+
+	return map[string]any{
+		"record_id":        r.RecordId,
+		"origin_record_id": r.RecordId,
+		"my_status":        myStatus,
+		"status":           r.Status,
+		"hd_wallet_id":     r.HDWalletId,
+		"action_type":      r.Action,
+		"note":             r.Note,
+		"tx_hash":          r.TxHash,
+		"create_time":      r.CreateTime.Format(time.DateTime),
+		"extra_data": map[string]any{
+			"txinfo":        txInfo,
+			"authorization": r.Authorization,
+			"custom_data":   r.CustomData,
+		},
+	}
+}
+
+func addressList(addresses map[string]string) []map[string]any {
+	chains := make([]string, 0, len(addresses))
+	for chain := range addresses {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+	var list []map[string]any
+	for _, chain := range chains {
+		list = append(list, map[string]any{"chain": chain, "address": addresses[chain]})
+	}
+	return list
+}
+
+func (f *FakeApi) pageParams(page, limit int) (int, int) {
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+	if f.MaxPageSize > 0 && limit > f.MaxPageSize {
+		limit = f.MaxPageSize
+	}
+	return page, limit
+}
+
+func paginate(records []*Record, page, limit int) []*Record {
+	start := (page - 1) * limit
+	if start >= len(records) {
+		return nil
+	}
+	end := start + limit
+	if end > len(records) {
+		end = len(records)
+	}
+	return records[start:end]
+}
+
+// 通过json转换为apisdk返回的结构体
+func convert(from any, to any) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
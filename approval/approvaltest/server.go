@@ -0,0 +1,378 @@
+package approvaltest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/internal/apisign"
+)
+
+// 模拟服务的行为脚本
+type Script struct {
+	Addresses  map[string]string    // 主钱包地址 chain -> address
+	HDWallets  map[string]*HDWallet // HD钱包 id -> 钱包
+	Approvals  []ScriptApproval     // 启动时添加的待审批记录，模拟其他人发起的审批
+	Decision   string               // 发起的审批自动处理: agree/reject，为空时保持ING
+	Delay      string               // 自动处理的延迟，如 3s
+	CustomData string               // 审批通过后设置的custom_data
+	FinalHash  string               // 审批通过后设置的authorization.final_hash
+	Errors     []ScriptError        // 按接口返回的错误
+}
+
+// 启动时添加的待审批记录
+type ScriptApproval struct {
+	HDWalletId string
+	Action     string
+	TxInfo     apisdk.TXInfo
+}
+
+// 接口返回的错误
+type ScriptError struct {
+	Path       string // 接口路径，如 /openapi/company_wallet/approvals/ 、/openapi/sign/send_transaction
+	StatusCode int    // http状态码，为0时返回200和错误码
+	ErrCode    string
+	ErrMsg     string
+	Times      int // 返回错误的次数，0为一直返回
+}
+
+// docker签名请求
+type SignRequest struct {
+	Method   string // sign_message/sign_transaction/send_transaction
+	RecordId string
+	Key      string
+}
+
+// 基于httptest的openblock企业钱包接口和docker签名接口(/openapi/sign/*)模拟服务
+type Server struct {
+	*httptest.Server
+	Api *FakeApi
+
+	// 不为空时校验请求的api_key和签名
+	ApiKey    string
+	ApiSecret string
+
+	mu           sync.Mutex
+	script       Script
+	errors       []*ScriptError
+	signRequests []SignRequest
+}
+
+// 启动模拟服务，api为nil时使用新的FakeApi
+func NewServer(api *FakeApi) *Server {
+	if api == nil {
+		api = NewFakeApi()
+	}
+	s := &Server{Api: api}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// 在指定地址启动模拟服务，如 localhost:7790，用于独立运行的模拟服务，api为nil时使用新的FakeApi
+func NewServerAt(api *FakeApi, addr string) (*Server, error) {
+	if api == nil {
+		api = NewFakeApi()
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Api: api}
+	s.Server = httptest.NewUnstartedServer(s)
+	s.Server.Listener.Close()
+	s.Server.Listener = l
+	s.Server.Start()
+	return s, nil
+}
+
+// 从json文件读取脚本
+func LoadScript(path string) (Script, error) {
+	var script Script
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+	err = json.Unmarshal(data, &script)
+	return script, err
+}
+
+// 设置行为脚本，添加脚本中的钱包地址和待审批记录
+func (s *Server) SetScript(script Script) error {
+	if script.Delay != "" {
+		if _, err := time.ParseDuration(script.Delay); err != nil {
+			return fmt.Errorf("invalid delay %q: %v", script.Delay, err)
+		}
+	}
+
+	s.Api.mu.Lock()
+	for chain, addr := range script.Addresses {
+		s.Api.Addresses[chain] = addr
+	}
+	for id, hdWallet := range script.HDWallets {
+		s.Api.HDWallets[id] = hdWallet
+	}
+	s.Api.mu.Unlock()
+	for _, appr := range script.Approvals {
+		s.Api.AddApproval(appr.HDWalletId, appr.Action, appr.TxInfo)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = script
+	s.errors = nil
+	for i := range script.Errors {
+		e := script.Errors[i]
+		s.errors = append(s.errors, &e)
+	}
+	return nil
+}
+
+// 收到的docker签名请求
+func (s *Server) SignRequests() []SignRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SignRequest(nil), s.signRequests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e := s.nextError(r.URL.Path); e != nil {
+		if e.StatusCode != 0 && e.StatusCode != http.StatusOK {
+			http.Error(w, e.ErrMsg, e.StatusCode)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/openapi/sign/") {
+			code, _ := strconv.Atoi(e.ErrCode)
+			if code == 0 {
+				code = 1
+			}
+			writeJson(w, map[string]any{"code": code, "message": e.ErrMsg})
+			return
+		}
+		writeError(w, e.ErrCode, e.ErrMsg)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/openapi/sign/") {
+		s.serveSign(w, r)
+		return
+	}
+
+	params, err := readParams(r)
+	if err != nil {
+		writeError(w, "400", err.Error())
+		return
+	}
+	if err := s.verifySign(params); err != nil {
+		writeError(w, "401", err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	var resp any
+	switch r.URL.Path {
+	case "/openapi/company_wallet/approvals/":
+		resp, err = s.Api.GetApprovals(ctx, &apisdk.ParamGetApprovals{
+			Page:   atoi(params["page"]),
+			Limit:  atoi(params["limit"]),
+			Status: params["status"],
+		})
+	case "/openapi/company_wallet/approvalsv2/":
+		// 直接返回响应内容，包含apisdk.RespApprovalsV2中没有的note
+		resp = s.Api.approvalsV2(&apisdk.ParamGetApprovalsV2{
+			Page:     atoi(params["page"]),
+			Limit:    atoi(params["limit"]),
+			ListType: params["list_type"],
+			RecordID: params["record_id"],
+		})
+	case "/openapi/company_wallet/approval/agree/":
+		resp, err = s.Api.AgreeApproval(ctx, &apisdk.ParamAgreeApproval{
+			RecordID: params["record_id"],
+			Agree:    params["agree"],
+		})
+		s.afterAgree(params["record_id"])
+	case "/openapi/company_wallet/approval/new/":
+		resp, err = s.newApproval(ctx, params)
+	case "/openapi/company_wallet/info/":
+		resp, err = s.Api.GetCompanyWalletInfo(ctx)
+	case "/openapi/company_wallet/hd_wallet_address/":
+		resp, err = s.Api.GetCompanyWalletHDWalletAddress(ctx, &apisdk.ParamGetCompanyWalletHDWalletAddress{
+			HDWalletID: params["hd_wallet_id"],
+		})
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, "400", err.Error())
+		return
+	}
+	writeJson(w, resp)
+}
+
+func (s *Server) newApproval(ctx context.Context, params map[string]string) (*apisdk.RespNewApproval, error) {
+	var txInfo apisdk.TXInfo
+	if err := json.Unmarshal([]byte(params["txinfo"]), &txInfo); err != nil {
+		return nil, fmt.Errorf("invalid txinfo: %v", err)
+	}
+	resp, err := s.Api.NewApproval(ctx, &apisdk.ParamNewApproval{
+		Action:         params["action"],
+		HDWalletID:     params["hd_wallet_id"],
+		TXInfo:         txInfo,
+		Note:           params["note"],
+		ExpiredTimeout: int32(atoi(params["expired_timeout"])),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	decision, delay := s.script.Decision, s.script.Delay
+	s.mu.Unlock()
+	if decision == "" {
+		return resp, nil
+	}
+	d, _ := time.ParseDuration(delay)
+	recordId := resp.Data.RecordId
+	time.AfterFunc(d, func() {
+		if decision == "reject" {
+			s.Api.Reject(recordId)
+			return
+		}
+		if s.Api.Agree(recordId) == nil {
+			s.afterAgree(recordId)
+		}
+	})
+	return resp, nil
+}
+
+// 审批通过后设置脚本中的签名结果
+func (s *Server) afterAgree(recordId string) {
+	s.mu.Lock()
+	customData, finalHash := s.script.CustomData, s.script.FinalHash
+	s.mu.Unlock()
+	s.Api.Update(recordId, func(r *Record) {
+		if r.Status != StatusAgree {
+			return
+		}
+		if customData != "" && r.CustomData == "" {
+			r.CustomData = customData
+		}
+		if finalHash != "" && r.Authorization == nil {
+			r.Authorization = &apisdk.Authorization{FinalHash: finalHash}
+		}
+	})
+}
+
+func (s *Server) serveSign(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RecordId string `json:"company_wallet_approve_record_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJson(w, map[string]any{"code": 1, "message": fmt.Sprintf("invalid body: %v", err)})
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/openapi/sign/")
+	switch method {
+	case "sign_message", "sign_transaction", "send_transaction":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.signRequests = append(s.signRequests, SignRequest{Method: method, RecordId: body.RecordId, Key: r.URL.Query().Get("key")})
+	s.mu.Unlock()
+
+	record, ok := s.Api.Record(body.RecordId)
+	if !ok {
+		writeJson(w, map[string]any{"code": 1, "message": "record not found"})
+		return
+	}
+	if record.Status != StatusAgree {
+		writeJson(w, map[string]any{"code": 1, "message": "record is not approved, status: " + record.Status})
+		return
+	}
+	writeJson(w, map[string]any{"code": 0, "message": "ok", "data": record.TxHash})
+}
+
+// 取出path对应的脚本错误
+func (s *Server) nextError(path string) *ScriptError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.errors {
+		if e.Path != path {
+			continue
+		}
+		if e.Times > 0 {
+			e.Times--
+			if e.Times == 0 {
+				s.errors = append(s.errors[:i], s.errors[i+1:]...)
+			}
+		}
+		return e
+	}
+	return nil
+}
+
+// 校验api_key和签名，与approval包使用同一个签名方法
+func (s *Server) verifySign(params map[string]string) error {
+	if s.ApiKey == "" || s.ApiSecret == "" {
+		return nil
+	}
+	if params["api_key"] != s.ApiKey {
+		return fmt.Errorf("invalid api_key")
+	}
+	signParams := make(map[string]any, len(params))
+	for k, v := range params {
+		signParams[k] = v
+	}
+	if !strings.EqualFold(apisign.Sign(signParams, s.ApiKey, s.ApiSecret), params["sign"]) {
+		return fmt.Errorf("invalid sign")
+	}
+	return nil
+}
+
+// 读取GET query或POST json参数
+func readParams(r *http.Request) (map[string]string, error) {
+	params := map[string]string{}
+	if r.Method == http.MethodPost {
+		// 数值使用json中的原文，与客户端签名时的格式一致，避免float64格式化为1e+06
+		var body map[string]any
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			return nil, err
+		}
+		for k, v := range body {
+			params[k] = fmt.Sprintf("%v", v)
+		}
+		return params, nil
+	}
+	for k, v := range r.URL.Query() {
+		params[k] = v[0]
+	}
+	return params, nil
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+func writeError(w http.ResponseWriter, code, msg string) {
+	writeJson(w, apisdk.RespError{Ok: false, ErrCode: code, ErrMsg: msg})
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,117 +1,208 @@
 package approval
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
 
 type ApprovalParams struct {
-	MatchParams  []VerifyParams
-	VerifyParams []VerifyParams
+	MatchParams    []VerifyParams
+	VerifyParams   []VerifyParams
+	VelocityLimits []VelocityLimit
+	// 匹配后的处理: approve检查VerifyParams后同意或拒绝（默认），reject直接拒绝，skip不审批并通知人工审批
+	Action string
 }
 
 type VerifyParams struct {
 	Path  string
 	Value string
 	Rule  string
+	Unit  string // 实际值的单位，规则值带单位时使用，如 wei、gwei、lamports、token、base，为空时按链和path推断
+	// 检查失败时的处理，只对VerifyParams顶层条件生效: reject拒绝（默认），skip不审批并通知人工审批
+	OnFail string
+
+	// 条件组合，可嵌套: All全部满足、Any任一满足、Not取反
+	All []VerifyParams
+	Any []VerifyParams
+	Not *VerifyParams
 }
 
 type ApproveResults struct {
 	ApprovalId string
 	Approved   bool
+	Skipped    bool // 未匹配到ApprovalParams或转人工审批，未审批
+	Escalated  bool // 转人工审批，记录保持待审批状态
 	Action     string
 	TxInfo     string
 	HdWalletID string
 	OnlySign   bool
+	DryRun     bool // 试运行，未实际提交审批
+	Trace      *DecisionTrace
+	Err        error // 提交审批失败的错误，失败时Approved为false
 }
 
 func AutoApprove(client *Client, approvalParams *[]ApprovalParams) ([]ApproveResults, error) {
-	apprs, err := client.GetApprovals("ING")
+	return AutoApproveContext(context.Background(), client, approvalParams)
+}
+
+func AutoApproveContext(ctx context.Context, client *Client, approvalParams *[]ApprovalParams) ([]ApproveResults, error) {
+	return AutoApprovePolicyContext(ctx, client, CompilePolicy(*approvalParams))
+}
+
+// 同AutoApproveContext，使用编译后的规则，定时审批时避免每次重新解析规则
+func AutoApprovePolicyContext(ctx context.Context, client *Client, policy *Policy) ([]ApproveResults, error) {
+	apprs, err := client.GetApprovalsContext(ctx, "ING")
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("Got %d approvals", len(apprs.Data))
 
+	// 清理已不在待审批列表中的转人工记录，避免一直增长
+	pending := make(map[string]bool, len(apprs.Data))
+	for _, appr := range apprs.Data {
+		pending[appr.RecordId] = true
+	}
+	client.escalated.Range(func(id, _ any) bool {
+		if !pending[id.(string)] {
+			client.escalated.Delete(id)
+		}
+		return true
+	})
+
 	var approveResult []ApproveResults
+	var recordErrs []error
 	for _, appr := range apprs.Data {
+		if err := ctx.Err(); err != nil {
+			return approveResult, errors.Join(append(recordErrs, err)...)
+		}
 		if appr.Status != "ING" {
 			continue
 		}
 
-		txInfoMap := convertTxInfoToMap(appr.ExtraData.Txinfo)
-		var approveParams *ApprovalParams
-		for _, params := range *approvalParams {
-			approveParams = &params
-			for _, param := range params.MatchParams {
-				if !CheckParam(txInfoMap, param) {
-					approveParams = nil
-					break
-				}
-			}
-			if approveParams != nil { //matched, break
-				break
-			}
-		}
+		txInfoMap := approvalTxInfoMap(policy.resources, appr.ExtraData.Txinfo, appr.Note)
+		trace := policy.evaluate(appr.RecordId, txInfoMap)
 		txInfo, _ := json.Marshal(appr.ExtraData.Txinfo)
-		if approveParams == nil {
+		result := ApproveResults{
+			ApprovalId: appr.RecordId,
+			Action:     appr.ActionType,
+			TxInfo:     string(txInfo),
+			HdWalletID: appr.HDWalletID,
+			OnlySign:   strings.HasSuffix(appr.ExtraData.Txinfo.BridgeMethod, "_signTransaction"),
+			Trace:      trace,
+		}
+		if trace.MatchedIndex < 0 {
+			result.Skipped = true
+			approveResult = append(approveResult, result)
 			log.Printf("No matched, skip approve, recordId: %s, txInfo %s\n", appr.RecordId, string(txInfo))
+			logDecisionTrace(trace)
 			continue
 		}
 
-		agree := true
-		for _, param := range approveParams.VerifyParams {
-			if !CheckParam(txInfoMap, param) {
-				agree = false
-				break
+		velocityLimits := policy.rules[trace.MatchedIndex].velocityLimits
+
+		var spends []SpendRecord
+		if trace.Decision == DecisionApprove && len(velocityLimits) > 0 {
+			spends, err = checkVelocityLimits(policy.resources, client.Store, velocityLimits, appr.RecordId, txInfoMap, time.Now())
+			if err != nil {
+				trace.Reason = err.Error()
+				trace.Decision = DecisionReject
 			}
 		}
+		if trace.Escalated {
+			result.Skipped = true
+			result.Escalated = true
+			result.DryRun = client.DryRun
+			approveResult = append(approveResult, result)
+			client.escalate(result)
+			logDecisionTrace(trace)
+			continue
+		}
 
-		res, err := client.AggreeApproval(appr.RecordId, agree)
-		if err != nil {
-			return nil, err
+		agree := trace.Decision == DecisionApprove
+		result.Approved = agree
+		if client.DryRun {
+			result.DryRun = true
+			approveResult = append(approveResult, result)
+			log.Printf("dry-run, would approve, recordId: %s, agree: %v, txInfo: %s\n", appr.RecordId, agree, string(txInfo))
+			logDecisionTrace(trace)
+			continue
 		}
-		approveResult = append(approveResult, ApproveResults{
-			ApprovalId: res.Data.RecordId,
-			Approved:   agree,
-			Action:     appr.ActionType,
-			TxInfo:     string(txInfo),
-			HdWalletID: appr.HDWalletID,
-			OnlySign:   strings.HasSuffix(appr.ExtraData.Txinfo.BridgeMethod, "_signTransaction"),
-		})
+
+		// 审批前先占用额度，接口超时等情况下审批可能已经生效，只在确定失败时释放
+		if agree {
+			if err := client.Store.AddSpends(spends); err != nil {
+				client.Store.RemoveSpends(appr.RecordId)
+				result.Approved = false
+				result.Err = fmt.Errorf("approve %s failed: save velocity records: %w", appr.RecordId, err)
+				recordErrs = append(recordErrs, result.Err)
+				approveResult = append(approveResult, result)
+				log.Printf("save velocity records failed, skip approve, recordId: %s, %v\n", appr.RecordId, err)
+				logDecisionTrace(trace)
+				continue
+			}
+		}
+		res, err := client.AggreeApprovalContext(ctx, appr.RecordId, agree)
+		if err != nil { //单条审批失败不影响其他审批
+			if agree && !IsRetryable(err) && ctx.Err() == nil {
+				if err := client.Store.RemoveSpends(appr.RecordId); err != nil {
+					log.Printf("remove velocity records failed, recordId: %s, %v\n", appr.RecordId, err)
+				}
+			}
+			result.Approved = false
+			result.Err = fmt.Errorf("approve %s failed: %w", appr.RecordId, err)
+			recordErrs = append(recordErrs, result.Err)
+			approveResult = append(approveResult, result)
+			log.Printf("auto approve failed, recordId: %s, agree: %v, %v\n", appr.RecordId, agree, err)
+			logDecisionTrace(trace)
+			continue
+		}
+		result.ApprovalId = res.Data.RecordId
+		approveResult = append(approveResult, result)
 		log.Printf("auto approve, recordId: %s, agree: %v, txInfo: %s\n", appr.RecordId, agree, string(txInfo))
+		logDecisionTrace(trace)
+	}
+	return approveResult, errors.Join(recordErrs...)
+}
 
+// 转人工审批，记录保持待审批状态，每个周期都会重新检查，只在第一次时通知
+func (client *Client) escalate(result ApproveResults) {
+	if _, notified := client.escalated.LoadOrStore(result.ApprovalId, true); notified {
+		return
+	}
+	log.Printf("escalate to manual review, recordId: %s, reason: %s, txInfo: %s\n", result.ApprovalId, result.Trace.Reason, result.TxInfo)
+	if client.OnEscalate != nil {
+		client.OnEscalate(result)
 	}
-	return approveResult, nil
 }
 
-// 根据不同的Type和Rule检查参数
-func CheckParam(txInfo map[string]interface{}, param VerifyParams) bool {
-	actualValue := getValueByPath(txInfo, param.Path)
-	if actualValue == nil {
-		return false
+// 以json格式输出决策过程，便于审计
+func logDecisionTrace(trace *DecisionTrace) {
+	traceJson, err := json.Marshal(trace)
+	if err != nil {
+		log.Printf("marshal decision trace failed, recordId: %s, %v\n", trace.ApprovalId, err)
+		return
 	}
+	log.Printf("decision trace: %s\n", string(traceJson))
+}
 
-	switch actual := actualValue.(type) {
-	case string:
-		actualDecimal, err := decimal.NewFromString(actual)
-		if err != nil {
-			return checkByRule(actual, param.Value, param.Rule)
-		} else {
-			return checkDecimalRule(actualDecimal, param.Value, param.Rule)
-		}
+// 根据不同的Type和Rule检查参数
+// 同一个VerifyParams中设置的Path/All/Any/Not需要同时满足
+func CheckParam(txInfo map[string]interface{}, param VerifyParams) bool {
+	return TraceParam(txInfo, param).Passed
+}
 
-	case []interface{}:
-		// 检查金额
-		return checkListRule(actual, param.Value, param.Rule)
-	default:
-		// 默认精确匹配
-		return false
-	}
+// 是否为条件组合节点
+func (p VerifyParams) isGroup() bool {
+	return len(p.All) > 0 || len(p.Any) > 0 || p.Not != nil
 }
 
 func checkListRule(actual []interface{}, expectedValue, rule string) bool {
@@ -189,37 +280,20 @@ func checkByRule(actualValue, expectedValue, rule string) bool {
 
 // decimal数值比较规则
 func checkDecimalRule(actualValue decimal.Decimal, expectedValue, rule string) bool {
-	expected, err := decimal.NewFromString(expectedValue)
-	if err != nil && rule != "range" {
-		return false
-	}
+	return parseDecimalRule(expectedValue, rule).check(actualValue)
+}
 
-	switch rule {
-	case "eq":
-		return actualValue.Equal(expected)
-	case "gt":
-		return actualValue.GreaterThan(expected)
-	case "gte":
-		return actualValue.GreaterThanOrEqual(expected)
-	case "lt":
-		return actualValue.LessThan(expected)
-	case "lte":
-		return actualValue.LessThanOrEqual(expected)
-	case "range":
-		// expectedValue 应该是 "min,max" 格式
-		parts := strings.Split(expectedValue, ",")
-		if len(parts) != 2 {
-			return false
-		}
-		min, err1 := decimal.NewFromString(parts[0])
-		max, err2 := decimal.NewFromString(parts[1])
-		if err1 != nil || err2 != nil {
-			return false
-		}
-		return actualValue.GreaterThanOrEqual(min) && actualValue.LessThanOrEqual(max)
-	default:
-		return actualValue.Equal(expected)
+// 发起审批时的备注保存在txInfo的note路径下
+const noteKey = "note"
+
+// 按审批记录生成规则检查使用的txInfo，添加解码结果和备注
+func approvalTxInfoMap(resources *PolicyResources, txInfo interface{}, note string) map[string]interface{} {
+	txInfoMap := convertTxInfoToMap(txInfo)
+	addDecoded(resources, txInfoMap)
+	if txInfoMap != nil && note != "" {
+		txInfoMap[noteKey] = note
 	}
+	return txInfoMap
 }
 
 // 将txInfo转换为map[string]interface{}
@@ -247,9 +321,11 @@ func convertTxInfoToMap(txInfo interface{}) map[string]interface{} {
 // 通过路径从map中获取值，支持嵌套路径，如 "ExtraData.Txinfo.chain"
 // 也支持数组索引，如 "transactions.0.to"
 func getValueByPath(m map[string]interface{}, path string) interface{} {
-	// 按点号分割路径
-	keys := strings.Split(path, ".")
+	return getValueByKeys(m, parsePath(path))
+}
 
+// 按拆分后的路径从map中获取值
+func getValueByKeys(m map[string]interface{}, keys []pathKey) interface{} {
 	var currentValue interface{} = m
 
 	// 逐级深入查找
@@ -258,18 +334,18 @@ func getValueByPath(m map[string]interface{}, path string) interface{} {
 		case map[string]interface{}:
 			// 如果当前值是map类型
 			var ok bool
-			currentValue, ok = current[key]
+			currentValue, ok = current[key.name]
 			if !ok {
 				// 键不存在
 				return nil
 			}
 		case []interface{}:
 			// 如果当前值是数组类型
-			index, ok := parseIndex(key)
-			if !ok {
+			if !key.isIndex {
 				// 不是有效的索引
 				return nil
 			}
+			index := key.index
 			if index >= len(current) {
 				// 索引越界
 				return nil
@@ -287,11 +363,3 @@ func getValueByPath(m map[string]interface{}, path string) interface{} {
 
 	return currentValue
 }
-
-func parseIndex(key string) (int, bool) {
-	index, err := strconv.Atoi(key)
-	if err != nil {
-		return 0, false
-	}
-	return index, true
-}
@@ -1,8 +1,13 @@
 package approval
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
@@ -243,6 +248,20 @@ func TestCheckDecimalRule(t *testing.T) {
 		result := checkDecimalRule(value, "100,invalid", "range")
 		assert.False(t, result)
 	})
+
+	t.Run("忽略前后空格", func(t *testing.T) {
+		assert.True(t, checkDecimalRule(value, " 100, 200 ", "range"))
+		assert.True(t, checkDecimalRule(value, " 101 ", "lt"))
+		txInfo := map[string]interface{}{"chain": "ETH", "value": "0.3"}
+		for _, param := range []VerifyParams{
+			{Path: "value", Value: " 0.1, 0.5 ", Rule: "range"},
+			{Path: "value", Value: " 0.5", Rule: "lt"},
+			{Path: "value", Value: "0.1 ETH, 0.5 ETH", Rule: "range"},
+		} {
+			assert.NoError(t, ValidateApprovalParams([]ApprovalParams{{VerifyParams: []VerifyParams{param}}}), param.Value)
+			assert.True(t, CheckParam(txInfo, param), param.Value)
+		}
+	})
 }
 
 func TestCheckListRule(t *testing.T) {
@@ -268,3 +287,393 @@ func TestCheckListRule(t *testing.T) {
 		assert.False(t, result)
 	})
 }
+
+func TestCheckParamGroup(t *testing.T) {
+	txInfo := map[string]interface{}{
+		"chain":  "ETH",
+		"to":     "0xtreasuryB",
+		"method": "transfer",
+		"amount": "5",
+	}
+
+	t.Run("Any任一满足", func(t *testing.T) {
+		param := VerifyParams{
+			Any: []VerifyParams{
+				{Path: "to", Value: "0xtreasuryA", Rule: "exact"},
+				{Path: "to", Value: "0xtreasuryB", Rule: "exact"},
+			},
+		}
+		assert.True(t, CheckParam(txInfo, param))
+
+		param.Any = param.Any[:1]
+		assert.False(t, CheckParam(txInfo, param))
+	})
+
+	t.Run("All全部满足", func(t *testing.T) {
+		param := VerifyParams{
+			All: []VerifyParams{
+				{Path: "chain", Value: "ETH", Rule: "exact"},
+				{Path: "amount", Value: "10", Rule: "lt"},
+			},
+		}
+		assert.True(t, CheckParam(txInfo, param))
+
+		param.All = append(param.All, VerifyParams{Path: "amount", Value: "1", Rule: "lt"})
+		assert.False(t, CheckParam(txInfo, param))
+	})
+
+	t.Run("Not取反", func(t *testing.T) {
+		param := VerifyParams{
+			Not: &VerifyParams{Path: "method", Value: "approve", Rule: "exact"},
+		}
+		assert.True(t, CheckParam(txInfo, param))
+
+		param.Not.Value = "transfer"
+		assert.False(t, CheckParam(txInfo, param))
+
+		// path不存在时取反也失败
+		param.Not = &VerifyParams{Path: "mehtod", Value: "approve", Rule: "exact"}
+		trace := TraceParam(txInfo, param)
+		assert.False(t, trace.Passed)
+		assert.Equal(t, "path mehtod not found", trace.Children[0].Reason)
+		param.Not = &VerifyParams{Any: []VerifyParams{{Path: "method", Value: "approve"}, {Path: "missing", Value: "1"}}}
+		assert.False(t, CheckParam(txInfo, param))
+	})
+
+	t.Run("Path与组合同时满足", func(t *testing.T) {
+		param := VerifyParams{
+			Path:  "chain",
+			Value: "ETH",
+			Rule:  "exact",
+			Not:   &VerifyParams{Path: "method", Value: "approve", Rule: "exact"},
+		}
+		assert.True(t, CheckParam(txInfo, param))
+
+		param.Value = "Solana"
+		assert.False(t, CheckParam(txInfo, param))
+	})
+
+	t.Run("嵌套组合", func(t *testing.T) {
+		param := VerifyParams{
+			All: []VerifyParams{
+				{Path: "chain", Value: "ETH", Rule: "exact"},
+				{
+					Any: []VerifyParams{
+						{Path: "to", Value: "0xtreasuryA", Rule: "exact"},
+						{Not: &VerifyParams{Path: "amount", Value: "1", Rule: "gt"}},
+					},
+				},
+			},
+		}
+		assert.False(t, CheckParam(txInfo, param))
+
+		param.All[1].Any[0].Value = "0xtreasuryB"
+		assert.True(t, CheckParam(txInfo, param))
+	})
+
+	t.Run("从json加载", func(t *testing.T) {
+		var params ApprovalParams
+		err := json.Unmarshal([]byte(`{
+			"matchParams": [{"path": "chain", "value": "ETH", "rule": "exact"}],
+			"verifyParams": [{
+				"any": [
+					{"path": "to", "value": "0xtreasuryA", "rule": "exact"},
+					{"path": "to", "value": "0xtreasuryB", "rule": "exact"}
+				],
+				"not": {"path": "method", "value": "approve", "rule": "exact"}
+			}]
+		}`), &params)
+		assert.NoError(t, err)
+		assert.Len(t, params.VerifyParams[0].Any, 2)
+		assert.True(t, CheckParam(txInfo, params.VerifyParams[0]))
+	})
+}
+
+func TestTraceParam(t *testing.T) {
+	txInfo := map[string]interface{}{
+		"chain":     "ETH",
+		"amount":    "100",
+		"contracts": []interface{}{"contract1"},
+		"eip1559":   true,
+	}
+
+	t.Run("通过时记录实际值", func(t *testing.T) {
+		trace := TraceParam(txInfo, VerifyParams{Path: "amount", Value: "200", Rule: "lt"})
+		assert.True(t, trace.Passed)
+		assert.Equal(t, "100", trace.Actual)
+		assert.Empty(t, trace.Reason)
+	})
+
+	t.Run("失败原因", func(t *testing.T) {
+		cases := []struct {
+			param  VerifyParams
+			reason string
+		}{
+			{VerifyParams{Path: "missing", Value: "1", Rule: "eq"}, "path not found"},
+			{VerifyParams{Path: "chain", Value: "1", Rule: "gt"}, `parse failure: "ETH" is not a number`},
+			{VerifyParams{Path: "amount", Value: "abc", Rule: "lt"}, `parse failure: rule value "abc" is not a number`},
+			{VerifyParams{Path: "amount", Value: "1", Rule: "range"}, `invalid range "1"`},
+			{VerifyParams{Path: "chain", Value: "[", Rule: "regex"}, "invalid regex"},
+			{VerifyParams{Path: "amount", Value: "10", Rule: "lt"}, "comparison failed"},
+			{VerifyParams{Path: "contracts", Value: "x", Rule: "length"}, "parse failure"},
+			{VerifyParams{Path: "contracts", Value: "x", Rule: "unknown"}, "unknown list rule"},
+			{VerifyParams{Path: "eip1559", Value: "true", Rule: "exact"}, "unsupported value type bool"},
+		}
+		for _, c := range cases {
+			trace := TraceParam(txInfo, c.param)
+			assert.False(t, trace.Passed)
+			assert.Contains(t, trace.Reason, c.reason)
+		}
+	})
+
+	t.Run("条件组合", func(t *testing.T) {
+		trace := TraceParam(txInfo, VerifyParams{
+			Any: []VerifyParams{
+				{Path: "chain", Value: "Solana", Rule: "exact"},
+				{Path: "amount", Value: "10", Rule: "lt"},
+			},
+		})
+		assert.False(t, trace.Passed)
+		assert.Equal(t, "any group failed", trace.Reason)
+		assert.Len(t, trace.Children, 1)
+		assert.Equal(t, "any", trace.Children[0].Group)
+		assert.Len(t, trace.Children[0].Children, 2)
+	})
+}
+
+func TestAutoApprove(t *testing.T) {
+	params := []ApprovalParams{
+		{
+			MatchParams:  []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}},
+			VerifyParams: []VerifyParams{{Path: "amount", Value: "10", Rule: "lt"}},
+		},
+	}
+
+	t.Run("审批匹配的记录", func(t *testing.T) {
+		api := approvaltest.NewFakeApi()
+		small := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		large := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "100"})
+		other := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "Solana", Value: "1"})
+		client := NewClientWithApi("key", "secret", api)
+
+		results, err := AutoApprove(client, &params)
+		assert.NoError(t, err)
+		assert.Len(t, results, 3)
+
+		r, _ := api.Record(small)
+		assert.Equal(t, approvaltest.StatusAgree, r.Status)
+		r, _ = api.Record(large)
+		assert.Equal(t, approvaltest.StatusReject, r.Status)
+		r, _ = api.Record(other)
+		assert.Equal(t, approvaltest.StatusIng, r.Status)
+
+		assert.True(t, results[0].Approved)
+		assert.Equal(t, DecisionApprove, results[0].Trace.Decision)
+		assert.False(t, results[1].Approved)
+		assert.Equal(t, DecisionReject, results[1].Trace.Decision)
+		assert.True(t, results[2].Skipped)
+		assert.Equal(t, -1, results[2].Trace.MatchedIndex)
+	})
+
+	t.Run("按解码后的calldata审批", func(t *testing.T) {
+		tokenParams := []ApprovalParams{{
+			MatchParams: []VerifyParams{{Path: "decoded.method", Value: "transfer", Rule: "exact"}},
+			VerifyParams: []VerifyParams{
+				{Path: "decoded.args.to", Value: "0x00000000000000000000000000000000000000bb", Rule: "exact"},
+				{Path: "decoded.args.amount", Value: "1000", Rule: "lte"},
+			},
+		}}
+		// transfer(0x...bb, 1000)
+		data := "0xa9059cbb00000000000000000000000000000000000000000000000000000000000000bb00000000000000000000000000000000000000000000000000000000000003e8"
+		api := approvaltest.NewFakeApi()
+		id := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", To: "0xtoken", Data: data})
+		client := NewClientWithApi("key", "secret", api)
+
+		results, err := AutoApprove(client, &tokenParams)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		r, _ := api.Record(id)
+		assert.Equal(t, approvaltest.StatusAgree, r.Status)
+	})
+
+	t.Run("试运行不提交审批", func(t *testing.T) {
+		api := approvaltest.NewFakeApi()
+		id := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		client := NewClientWithApi("key", "secret", api)
+		client.DryRun = true
+
+		results, err := AutoApprove(client, &params)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.True(t, results[0].Approved)
+		assert.True(t, results[0].DryRun)
+		assert.Equal(t, 0, api.Calls("AgreeApproval"))
+		r, _ := api.Record(id)
+		assert.Equal(t, approvaltest.StatusIng, r.Status)
+	})
+
+	t.Run("分页查询所有审批", func(t *testing.T) {
+		api := approvaltest.NewFakeApi()
+		for i := 0; i < 5; i++ {
+			api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "Solana", Value: "1"})
+		}
+		api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		client := NewClientWithApi("key", "secret", api)
+		client.PageSize = 2
+
+		results, err := AutoApprove(client, &params)
+		assert.NoError(t, err)
+		assert.Len(t, results, 6)
+		assert.True(t, results[5].Approved)
+	})
+
+	t.Run("服务端限制每页数量时查询所有审批", func(t *testing.T) {
+		api := approvaltest.NewFakeApi()
+		api.MaxPageSize = 2
+		for i := 0; i < 5; i++ {
+			api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "Solana", Value: "1"})
+		}
+		client := NewClientWithApi("key", "secret", api)
+
+		apprs, err := client.GetApprovals("ING")
+		assert.NoError(t, err)
+		assert.Len(t, apprs.Data, 5)
+		sponsored, err := client.GetSponsoredApprovals("")
+		assert.NoError(t, err)
+		assert.Empty(t, sponsored.Data.Data)
+	})
+
+	t.Run("转人工审批", func(t *testing.T) {
+		escalateParams := []ApprovalParams{
+			{
+				MatchParams: []VerifyParams{{Path: "chain", Value: "Solana", Rule: "exact"}},
+				Action:      DecisionSkip,
+			},
+			{
+				MatchParams:  []VerifyParams{{Path: "chain", Value: "BSC", Rule: "exact"}},
+				Action:       DecisionReject,
+				VerifyParams: []VerifyParams{{Path: "amount", Value: "10", Rule: "lt"}},
+			},
+			{
+				MatchParams: []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}},
+				VerifyParams: []VerifyParams{
+					{Path: "amount", Value: "10", Rule: "lt", OnFail: DecisionSkip},
+					{Path: "to", Value: "0x1", Rule: "exact"},
+				},
+			},
+		}
+		api := approvaltest.NewFakeApi()
+		solana := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "Solana", Value: "1"})
+		bsc := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "BSC", Value: "1"})
+		large := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", To: "0x1", Value: "100"})
+		both := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", To: "0x2", Value: "100"})
+		client := NewClientWithApi("key", "secret", api)
+		var notified []string
+		client.OnEscalate = func(result ApproveResults) {
+			notified = append(notified, result.ApprovalId)
+		}
+
+		results, err := AutoApprove(client, &escalateParams)
+		assert.NoError(t, err)
+		assert.Len(t, results, 4)
+
+		for _, id := range []string{solana, large} {
+			r, _ := api.Record(id)
+			assert.Equal(t, approvaltest.StatusIng, r.Status)
+		}
+		r, _ := api.Record(bsc)
+		assert.Equal(t, approvaltest.StatusReject, r.Status)
+		r, _ = api.Record(both)
+		assert.Equal(t, approvaltest.StatusReject, r.Status)
+
+		assert.True(t, results[0].Escalated)
+		assert.Equal(t, "approval params action is skip", results[0].Trace.Reason)
+		assert.Equal(t, "approval params action is reject", results[1].Trace.Reason)
+		assert.Empty(t, results[1].Trace.Verify)
+		assert.True(t, results[2].Escalated)
+		assert.Equal(t, DecisionSkip, results[2].Trace.Decision)
+		assert.Equal(t, "verify param amount failed: comparison failed", results[2].Trace.Reason)
+		assert.False(t, results[3].Escalated)
+		assert.Equal(t, "verify param to failed: comparison failed", results[3].Trace.Reason)
+		assert.Equal(t, []string{solana, large}, notified)
+
+		// 下个周期仍为待审批，不重复通知
+		results, err = AutoApprove(client, &escalateParams)
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		assert.Len(t, notified, 2)
+
+		// 人工审批后不再保留通知记录
+		assert.NoError(t, api.Agree(solana))
+		results, err = AutoApprove(client, &escalateParams)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		_, ok := client.escalated.Load(solana)
+		assert.False(t, ok)
+		_, ok = client.escalated.Load(large)
+		assert.True(t, ok)
+		assert.Len(t, notified, 2)
+	})
+	t.Run("按备注匹配", func(t *testing.T) {
+		noteParams := []ApprovalParams{{
+			MatchParams:  []VerifyParams{{Path: "note", Value: "^INV-", Rule: "regex"}},
+			VerifyParams: []VerifyParams{{Path: "value", Value: "10", Rule: "lt"}},
+		}}
+		api := approvaltest.NewFakeApi()
+		invoice := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		api.Update(invoice, func(r *approvaltest.Record) { r.Note = "INV-1001" })
+		other := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		client := NewClientWithApi("key", "secret", api)
+
+		results, err := AutoApprove(client, &noteParams)
+		assert.NoError(t, err)
+		assert.True(t, results[0].Approved)
+		assert.True(t, results[1].Skipped)
+		assert.Equal(t, "path not found", results[1].Trace.Match[0].Params[0].Reason)
+		r, _ := api.Record(other)
+		assert.Equal(t, approvaltest.StatusIng, r.Status)
+
+		policy := CompilePolicy(noteParams)
+		assert.Equal(t, DecisionApprove, policy.EvaluateNote(apisdk.TXInfo{Chain: "ETH", Value: "1"}, "INV-1001").Decision)
+		assert.Equal(t, DecisionSkip, policy.Evaluate(apisdk.TXInfo{Chain: "ETH", Value: "1"}).Decision)
+	})
+
+	t.Run("审批失败时按错误类型释放额度", func(t *testing.T) {
+		limitParams := []ApprovalParams{{
+			MatchParams:    []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}},
+			VelocityLimits: []VelocityLimit{{Name: "count", Window: "1h", MaxCount: 5}},
+		}}
+		for _, c := range []struct {
+			name string
+			err  error
+			kept int
+		}{
+			{"临时错误保留额度", &ApiError{Kind: ErrorTransient, Message: "timeout"}, 1},
+			{"确定失败释放额度", &ApiError{Kind: ErrorPermanent, Message: "record not found"}, 0},
+		} {
+			api := &agreeErrApi{FakeApi: approvaltest.NewFakeApi(), err: c.err}
+			api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+			client := NewClientWithApi("key", "secret", api)
+			client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+			results, err := AutoApprove(client, &limitParams)
+			assert.Error(t, err, c.name)
+			assert.False(t, results[0].Approved, c.name)
+			assert.Len(t, client.Store.Spends("count", time.Now()), c.kept, c.name)
+		}
+	})
+}
+
+// AgreeApproval固定返回错误的接口
+type agreeErrApi struct {
+	*approvaltest.FakeApi
+	err   error
+	apply bool // 返回错误前先提交审批，模拟审批已生效但响应超时
+}
+
+func (a *agreeErrApi) AgreeApproval(ctx context.Context, params *apisdk.ParamAgreeApproval) (*apisdk.RespAgreeApproval, error) {
+	if a.apply {
+		a.FakeApi.AgreeApproval(ctx, params)
+	}
+	return nil, a.err
+}
@@ -0,0 +1,355 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+)
+
+// 批量发起的一笔审批
+type BatchItem struct {
+	Id              string         `json:"id"`            // 调用方的业务id，用于对应结果，可以为空
+	Key             string         `json:"key,omitempty"` // 幂等key，不为空时同SubmitApprovalTxInfoWithKey，重新执行时不重复发起
+	HdWalletId      string         `json:"hdWalletId"`
+	TxInfo          *apisdk.TXInfo `json:"txInfo"`
+	ApprovalOptions                // 审批备注、过期时间等，json中与id同级
+}
+
+// 批量发起中每笔审批的结果，顺序与BatchItem一致
+type BatchResult struct {
+	Id     string
+	Handle *ApprovalHandle // 发起失败时为nil，超时后可以通过WaitApproval继续等待
+	Status string          // ING/AGREE/REJECT，发起失败时为空
+	Result string          // 审批通过时的txHash/签名/rawTx
+	Err    error           // 发起失败、审批被拒绝或等待超时
+}
+
+// 批量发起的并发和限速，为0的字段使用默认值
+type BatchOptions struct {
+	Concurrency int         // 同时发起审批的数量，默认5
+	RateLimit   float64     // 每秒最多发起的审批数量，为0时不限制
+	NoWait      bool        // 只发起审批，不等待审批结果
+	Wait        WaitOptions // 等待审批结果的查询间隔和超时时间，所有审批共用一个超时时间
+}
+
+const defaultBatchConcurrency = 5
+
+/*
+  - 批量发起审批并等待审批结果
+    按Concurrency并发、按RateLimit限速发起审批，发起后通过GetApprovalsV2统一查询所有审批的状态
+    返回值: 每笔审批的结果，单笔失败记录在BatchResult.Err中，查询审批状态失败或ctx取消时同时返回错误
+*/
+func SendBatch(client *Client, items []BatchItem, opts BatchOptions) ([]BatchResult, error) {
+	return SendBatchContext(context.Background(), client, items, opts)
+}
+
+func SendBatchContext(ctx context.Context, client *Client, items []BatchItem, opts BatchOptions) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	for i, item := range items {
+		results[i].Id = item.Id
+	}
+	start := time.Now()
+	submitBatch(ctx, client, items, results, opts)
+	if opts.NoWait {
+		return results, ctx.Err()
+	}
+	return results, waitBatch(ctx, client, results, start, opts.Wait)
+}
+
+func submitBatch(ctx context.Context, client *Client, items []BatchItem, results []BatchResult, opts BatchOptions) {
+	// 预先查询钱包地址，WalletInfoMap不支持并发写入
+	walletErrs := map[string]error{}
+	for _, item := range items {
+		if _, ok := walletErrs[item.HdWalletId]; !ok {
+			_, err := client.GetHDWalletInfoContext(ctx, item.HdWalletId)
+			walletErrs[item.HdWalletId] = err
+		}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	limiter := newRateLimiter(opts.RateLimit)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < min(concurrency, len(items)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				item := items[i]
+				if err := walletErrs[item.HdWalletId]; err != nil {
+					results[i].Err = fmt.Errorf("GetHDWalletInfo error: %w", err)
+					continue
+				}
+				if item.TxInfo == nil {
+					results[i].Err = fmt.Errorf("txInfo is required")
+					continue
+				}
+				if err := limiter.wait(ctx); err != nil {
+					results[i].Err = err
+					continue
+				}
+				if item.Key != "" {
+					results[i].Handle, results[i].Err = SubmitApprovalTxInfoWithKeyContext(ctx, client, item.Key, item.HdWalletId, item.TxInfo, item.ApprovalOptions)
+				} else {
+					results[i].Handle, results[i].Err = SubmitApprovalTxInfoContext(ctx, client, item.HdWalletId, item.TxInfo, item.ApprovalOptions)
+				}
+				if results[i].Err == nil {
+					results[i].Status = StatusIng
+				}
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// 统一查询已发起审批的状态，直到全部完成、超时或ctx取消，start为开始发起审批的时间
+func waitBatch(ctx context.Context, client *Client, results []BatchResult, start time.Time, opts WaitOptions) error {
+	opts = opts.withDefaults()
+	pending := map[string]int{}
+	for i, r := range results {
+		if r.Handle != nil && r.Err == nil {
+			pending[r.Handle.RecordId] = i
+		}
+	}
+	// 未完成的审批都记录同一个错误
+	fail := func(format string, err error) {
+		for recordId, i := range pending {
+			results[i].Err = fmt.Errorf(format, err, recordId)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := pollApprovals(ctx, opts, fmt.Sprintf("batch of %d approvals", len(pending)), func() (bool, error) {
+		err := pollBatch(ctx, client, results, pending, start)
+		return len(pending) == 0, err
+	})
+	if errors.Is(err, ErrApprovalTimeout) {
+		fail("%w, recordId: %s, approval is still pending", ErrApprovalTimeout)
+		return nil
+	}
+	if err != nil {
+		fail("%w, recordId: %s", err)
+	}
+	return err
+}
+
+// 服务端和本地的时钟误差，按创建时间停止翻页时使用
+const batchClockSkew = time.Minute
+
+/*
+  - 分页查询发起的审批，更新pending中审批的状态，已完成的审批从pending中删除
+    发起的审批按创建时间倒序，所有pending都查到、查到空页或翻到start之前创建的审批后不再查询下一页
+    翻页期间有新的审批时同一条记录可能出现在相邻两页，按recordId去重
+    分页中没有查到的审批（如使用幂等key恢复的之前发起的审批）按recordId单独查询
+*/
+func pollBatch(ctx context.Context, client *Client, results []BatchResult, pending map[string]int, start time.Time) error {
+	limit := client.pageSize()
+	total := len(pending)
+	found := make(map[string]bool, total)
+	update := func(recordId, status, actionType, txHash string, extra apisdk.ExtraData) {
+		i, ok := pending[recordId]
+		if !ok || found[recordId] {
+			return
+		}
+		found[recordId] = true
+		res := approvalStatus(results[i].Handle, status, actionType, txHash, extra)
+		results[i].Status = res.Status
+		if res.Done {
+			results[i].Result, results[i].Err = res.Result, res.Err
+			delete(pending, recordId)
+		}
+	}
+
+	for page := 1; page <= maxPages && len(found) < total; page++ {
+		resp, err := client.GetSponsoredApprovalsPageContext(ctx, "", page, limit)
+		if err != nil {
+			return err
+		}
+		if len(resp.Data.Data) == 0 {
+			break
+		}
+		for _, appr := range resp.Data.Data {
+			update(appr.RecordID, appr.Status, appr.ActionType, appr.TxHash, appr.ExtraData)
+		}
+		last := resp.Data.Data[len(resp.Data.Data)-1]
+		if created, err := time.ParseInLocation(time.DateTime, last.CreateTime, time.Local); err == nil && created.Before(start.Add(-batchClockSkew)) {
+			break
+		}
+	}
+
+	for recordId := range pending {
+		if found[recordId] {
+			continue
+		}
+		resp, err := client.GetSponsoredApprovalsPageContext(ctx, recordId, 1, 1)
+		if err != nil {
+			return err
+		}
+		for _, appr := range resp.Data.Data {
+			if appr.RecordID == recordId {
+				update(appr.RecordID, appr.Status, appr.ActionType, appr.TxHash, appr.ExtraData)
+			}
+		}
+	}
+	return nil
+}
+
+// 按固定间隔限速，多个goroutine共用
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// perSecond为0时不限速
+func newRateLimiter(perSecond float64) *rateLimiter {
+	l := &rateLimiter{}
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return l
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	return sleepContext(ctx, time.Until(at))
+}
+
+/*
+  - 读取批量发起的文件，.csv为csv格式，其他为jsonl格式
+    jsonl: 每行一个BatchItem，如 {"id": "1", "hdWalletId": "-", "txInfo": {"chain": "ETH", "to": "0x...", "value": "0.1"}}
+    csv: 第一行为表头，id、key、hdWalletId、note、expiredSeconds、action列为BatchItem字段，其他列为txInfo的json字段名，空值忽略
+    csv中{或[开头的值按json解析，useMaxAmount、isNative、eip1559、activeTokenEnum按json解析，其他按字符串
+*/
+func ReadBatchFile(path string) ([]BatchItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var items []BatchItem
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		items, err = readBatchCsv(f)
+	} else {
+		items, err = readBatchJsonl(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", path, err)
+	}
+	return items, nil
+}
+
+func readBatchJsonl(r io.Reader) ([]BatchItem, error) {
+	var items []BatchItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var item BatchItem
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if item.TxInfo == nil {
+			return nil, fmt.Errorf("line %d: txInfo is required", line)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// 非字符串类型的txInfo字段
+var batchCsvJsonFields = map[string]bool{"useMaxAmount": true, "isNative": true, "eip1559": true, "activeTokenEnum": true}
+
+func readBatchCsv(r io.Reader) ([]BatchItem, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("line 1: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	var items []BatchItem
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		item := BatchItem{}
+		fields := map[string]json.RawMessage{}
+		for i, value := range row {
+			value = strings.TrimSpace(value)
+			switch {
+			case value == "":
+			case header[i] == "id":
+				item.Id = value
+			case header[i] == "key":
+				item.Key = value
+			case header[i] == "hdWalletId":
+				item.HdWalletId = value
+			case header[i] == "note":
+				item.Note = value
+			case header[i] == "action":
+				item.Action = value
+			case header[i] == "expiredSeconds":
+				seconds, err := strconv.ParseInt(value, 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid expiredSeconds %q", line, value)
+				}
+				item.ExpiredSeconds = int32(seconds)
+			case batchCsvJsonFields[header[i]] || strings.HasPrefix(value, "{") || strings.HasPrefix(value, "["):
+				fields[header[i]] = json.RawMessage(value)
+			default:
+				fields[header[i]], _ = json.Marshal(value)
+			}
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := json.Unmarshal(data, &item.TxInfo); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item)
+	}
+}
@@ -0,0 +1,280 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendBatch(t *testing.T) {
+	newApi := func() *approvaltest.FakeApi {
+		api := approvaltest.NewFakeApi()
+		api.Addresses["ETH"] = "0xfrom"
+		api.HDWallets["hd1"] = &approvaltest.HDWallet{WalletName: "hd", Addresses: map[string]string{"ETH": "0xhdfrom"}}
+		return api
+	}
+	newItems := func(values ...string) []BatchItem {
+		var items []BatchItem
+		for _, value := range values {
+			items = append(items, BatchItem{Id: "pay-" + value, HdWalletId: "hd1", TxInfo: &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: value}})
+		}
+		return items
+	}
+	wait := WaitOptions{Interval: 10 * time.Millisecond, Timeout: time.Second}
+
+	t.Run("批量发起并等待结果", func(t *testing.T) {
+		api := newApi()
+		api.OnNewApproval = func(r *approvaltest.Record) {
+			switch r.TxInfo.Value {
+			case "1":
+				r.Status = approvaltest.StatusAgree
+				r.TxHash = "0xhash1"
+			case "2":
+				r.Status = approvaltest.StatusReject
+			}
+		}
+		client := NewClientWithApi("key", "secret", api)
+		client.PageSize = 2
+
+		items := newItems("1", "2", "3")
+		go func() {
+			// 第三笔审批稍后通过
+			for {
+				time.Sleep(5 * time.Millisecond)
+				for _, id := range []string{"record-1", "record-2", "record-3"} {
+					if r, ok := api.Record(id); ok && r.TxInfo.Value == "3" {
+						api.Update(id, func(r *approvaltest.Record) {
+							r.Status = approvaltest.StatusAgree
+							r.TxHash = "0xhash3"
+						})
+						return
+					}
+				}
+			}
+		}()
+		results, err := SendBatchContext(context.Background(), client, items, BatchOptions{Concurrency: 2, Wait: wait})
+		assert.NoError(t, err)
+		assert.Len(t, results, 3)
+
+		assert.Equal(t, "pay-1", results[0].Id)
+		assert.Equal(t, approvaltest.StatusAgree, results[0].Status)
+		assert.Equal(t, "0xhash1", results[0].Result)
+		assert.NoError(t, results[0].Err)
+
+		assert.Equal(t, approvaltest.StatusReject, results[1].Status)
+		assert.True(t, errors.Is(results[1].Err, ErrApprovalRejected))
+
+		assert.Equal(t, "0xhash3", results[2].Result)
+		assert.NoError(t, results[2].Err)
+
+		r, _ := api.Record(results[2].Handle.RecordId)
+		assert.Equal(t, "0xhdfrom", r.TxInfo.From)
+		assert.Equal(t, 3, api.Calls("NewApproval"))
+		assert.Equal(t, 1, api.Calls("GetCompanyWalletHDWalletAddress"))
+	})
+
+	t.Run("服务端每页数量小于PageSize时继续查询下一页", func(t *testing.T) {
+		api := newApi()
+		api.AutoAgree = true
+		api.MaxPageSize = 1
+		client := NewClientWithApi("key", "secret", api)
+		client.PageSize = 2
+
+		results, err := SendBatch(client, newItems("1", "2", "3"), BatchOptions{Wait: wait})
+		assert.NoError(t, err)
+		for _, r := range results {
+			assert.NoError(t, r.Err)
+			assert.Equal(t, approvaltest.StatusAgree, r.Status)
+		}
+	})
+
+	t.Run("发起失败记录在结果中", func(t *testing.T) {
+		api := newApi()
+		api.AutoAgree = true
+		client := NewClientWithApi("key", "secret", api)
+
+		items := newItems("1")
+		items = append(items, BatchItem{Id: "unknown-wallet", HdWalletId: "hd2", TxInfo: &apisdk.TXInfo{Chain: "ETH"}})
+		items = append(items, BatchItem{Id: "no-txinfo", HdWalletId: "hd1"})
+		results, err := SendBatch(client, items, BatchOptions{Wait: wait})
+		assert.NoError(t, err)
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, approvaltest.StatusAgree, results[0].Status)
+		assert.ErrorContains(t, results[1].Err, "hd wallet hd2 not found")
+		assert.Nil(t, results[1].Handle)
+		assert.ErrorContains(t, results[2].Err, "txInfo is required")
+		assert.Equal(t, 1, api.Calls("NewApproval"))
+	})
+
+	t.Run("超时后可以继续等待", func(t *testing.T) {
+		api := newApi()
+		client := NewClientWithApi("key", "secret", api)
+
+		results, err := SendBatch(client, newItems("1", "2"), BatchOptions{Wait: WaitOptions{Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond}})
+		assert.NoError(t, err)
+		for _, r := range results {
+			assert.True(t, errors.Is(r.Err, ErrApprovalTimeout))
+			assert.Equal(t, approvaltest.StatusIng, r.Status)
+		}
+
+		api.Agree(results[1].Handle.RecordId)
+		api.Update(results[1].Handle.RecordId, func(r *approvaltest.Record) { r.TxHash = "0xhash" })
+		txHash, err := WaitApproval(client, results[1].Handle, wait)
+		assert.NoError(t, err)
+		assert.Equal(t, "0xhash", txHash)
+	})
+
+	t.Run("只翻到发起之前的审批并单独查询其余审批", func(t *testing.T) {
+		api := newApi()
+		client := NewClientWithApi("key", "secret", api)
+		client.PageSize = 2
+		submit := func() string {
+			res, err := client.NewApproval("", "TRANSACTION", &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"}, "", 0)
+			assert.NoError(t, err)
+			return res.Data.RecordId
+		}
+		var before []string
+		for i := 0; i < 10; i++ {
+			id := submit()
+			api.Update(id, func(r *approvaltest.Record) { r.CreateTime = r.CreateTime.Add(-time.Hour) })
+			before = append(before, id)
+		}
+		start := time.Now()
+		// 第一笔为之前发起的审批，如使用幂等key恢复的审批
+		ids := []string{before[0], submit(), submit()}
+		for _, id := range ids {
+			api.Update(id, func(r *approvaltest.Record) {
+				r.Status = approvaltest.StatusAgree
+				r.TxHash = "0xhash"
+			})
+		}
+
+		results := make([]BatchResult, len(ids))
+		pending := map[string]int{}
+		for i, id := range ids {
+			results[i].Handle = &ApprovalHandle{RecordId: id}
+			pending[id] = i
+		}
+		assert.NoError(t, pollBatch(context.Background(), client, results, pending, start))
+		assert.Empty(t, pending)
+		for _, r := range results {
+			assert.Equal(t, approvaltest.StatusAgree, r.Status)
+			assert.Equal(t, "0xhash", r.Result)
+		}
+		// 第1页为新发起的审批，第2页为之前的审批后停止翻页，再单独查询1条
+		assert.Equal(t, 3, api.Calls("GetApprovalsV2"))
+	})
+
+	t.Run("只发起不等待并限速", func(t *testing.T) {
+		api := newApi()
+		client := NewClientWithApi("key", "secret", api)
+
+		start := time.Now()
+		results, err := SendBatch(client, newItems("1", "2", "3", "4", "5"), BatchOptions{Concurrency: 5, RateLimit: 50, NoWait: true})
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+		for _, r := range results {
+			assert.NoError(t, r.Err)
+			assert.Equal(t, StatusIng, r.Status)
+			assert.NotEmpty(t, r.Handle.RecordId)
+		}
+		assert.Equal(t, 0, api.Calls("GetApprovalsV2"))
+	})
+
+	t.Run("幂等key重新执行时不重复发起", func(t *testing.T) {
+		api := newApi()
+		client := NewClientWithApi("key", "secret", api)
+		items := newItems("1", "2")
+		for i := range items {
+			items[i].Key = items[i].Id
+		}
+		// 只保存在内存中时不能使用幂等key
+		results, err := SendBatch(client, items, BatchOptions{NoWait: true})
+		assert.NoError(t, err)
+		for _, r := range results {
+			assert.ErrorContains(t, r.Err, "requires a store with a file path")
+		}
+		assert.Equal(t, 0, api.Calls("NewApproval"))
+
+		store, err := NewStore(filepath.Join(t.TempDir(), "store.json"))
+		assert.NoError(t, err)
+		client.Store = store
+
+		first, err := SendBatch(client, items, BatchOptions{NoWait: true})
+		assert.NoError(t, err)
+		api.Agree(first[0].Handle.RecordId)
+		api.Agree(first[1].Handle.RecordId)
+
+		results, err = SendBatch(client, items, BatchOptions{Wait: wait})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, api.Calls("NewApproval"))
+		for i, r := range results {
+			assert.Equal(t, first[i].Handle.RecordId, r.Handle.RecordId)
+			assert.Equal(t, approvaltest.StatusAgree, r.Status)
+		}
+	})
+
+	t.Run("context取消", func(t *testing.T) {
+		client := NewClientWithApi("key", "secret", newApi())
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		results, err := SendBatchContext(ctx, client, newItems("1"), BatchOptions{Wait: wait})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, results[0].Err, context.DeadlineExceeded)
+		assert.NotNil(t, results[0].Handle)
+	})
+}
+
+func TestReadBatchFile(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("csv", func(t *testing.T) {
+		path := filepath.Join(dir, "batch.csv")
+		data := "id,hdWalletId,note,chain,to,value,transaction_type,isNative,token\n" +
+			"pay-1,hd1,INV-1,ETH,0xto1,0.1,native,true,\n" +
+			`pay-2,,,BSC,0xto2,2,contract,,"{""symbol"": ""USDT""}"` + "\n"
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+		items, err := ReadBatchFile(path)
+		assert.NoError(t, err)
+		assert.Len(t, items, 2)
+		assert.Equal(t, "pay-1", items[0].Id)
+		assert.Equal(t, "hd1", items[0].HdWalletId)
+		assert.Equal(t, "INV-1", items[0].Note)
+		assert.Equal(t, apisdk.TXInfo{Chain: "ETH", To: "0xto1", Value: "0.1", TransactionType: "native", IsNative: true}, *items[0].TxInfo)
+		assert.Equal(t, "", items[1].HdWalletId)
+		assert.Equal(t, "BSC", items[1].TxInfo.Chain)
+		assert.Equal(t, "USDT", items[1].TxInfo.Token.Symbol)
+
+		assert.NoError(t, os.WriteFile(path, []byte("id,chain,isNative\npay-1,ETH,yes\n"), 0644))
+		_, err = ReadBatchFile(path)
+		assert.ErrorContains(t, err, "line 2")
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		path := filepath.Join(dir, "batch.jsonl")
+		data := `{"id": "pay-1", "hdWalletId": "-", "txInfo": {"chain": "ETH", "to": "0xto", "value": "1"}}` + "\n\n" +
+			`{"id": "pay-2", "note": "INV-2", "expiredSeconds": 600, "txInfo": {"chain": "Solana", "activeTokenEnum": 1}}` + "\n"
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+		items, err := ReadBatchFile(path)
+		assert.NoError(t, err)
+		assert.Len(t, items, 2)
+		assert.Equal(t, "-", items[0].HdWalletId)
+		assert.Equal(t, "0xto", items[0].TxInfo.To)
+		assert.Equal(t, 1, items[1].TxInfo.ActiveTokenEnum)
+		assert.Equal(t, ApprovalOptions{Note: "INV-2", ExpiredSeconds: 600}, items[1].ApprovalOptions)
+
+		assert.NoError(t, os.WriteFile(path, []byte(`{"id": "pay-1"}`), 0644))
+		_, err = ReadBatchFile(path)
+		assert.ErrorContains(t, err, "line 1: txInfo is required")
+	})
+}
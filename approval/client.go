@@ -1,18 +1,36 @@
 package approval
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
 )
 
+const defaultPageSize = 20
+
+// 分页查询的最大页数，防止接口异常时无限查询
+const maxPages = 500
+
 type Client struct {
 	ApiKey        string
 	ApiSecret     string
-	apiClient     *apisdk.Client
+	apiClient     OpenBlockApi
 	WalletInfoMap map[string]*WalletInfo
+	Store         *Store
+	DryRun        bool // 试运行，只评估审批，不调用审批和签名接口
+	PageSize      int  // 分页查询每页数量，默认20
+	RetryPolicy   RetryPolicy
+	WaitOptions   WaitOptions // 发起审批后等待审批结果的查询间隔和超时时间
+	// 待签名记录的最大签名尝试次数，超过后移出队列，0为一直重试
+	MaxSignAttempts int
+	// 转人工审批时的通知，同一条记录只通知一次
+	OnEscalate func(result ApproveResults)
+	escalated  sync.Map
 }
 
 type WalletInfo struct {
@@ -24,77 +42,304 @@ type WalletInfo struct {
 }
 
 func NewClient(apiKey, apiSecret string) *Client {
-	client := apisdk.NewClient(apiKey, apiSecret, 10*time.Second)
+	return NewClientWithApi(apiKey, apiSecret, newHttpApi(apiKey, apiSecret, 10*time.Second))
+}
+
+// 使用自定义的openblock接口实现创建Client，用于测试或mock
+func NewClientWithApi(apiKey, apiSecret string, api OpenBlockApi) *Client {
 	return &Client{
 		ApiKey:        apiKey,
 		ApiSecret:     apiSecret,
-		apiClient:     client,
+		apiClient:     api,
 		WalletInfoMap: make(map[string]*WalletInfo),
+		Store:         &Store{},
+		RetryPolicy:   DefaultRetryPolicy,
 	}
 }
 
+// 修改openblock接口地址，如本地模拟服务approvaltest.Server或代理
+func (c *Client) SetApiUrl(apiUrl string) {
+	api := newHttpApi(c.ApiKey, c.ApiSecret, 10*time.Second)
+	api.baseUrl = apiUrl
+	c.apiClient = api
+}
+
+// 遍历所有分页查询审批列表，按recordId去重
 func (c *Client) GetApprovals(status string) (*apisdk.RespApprovals, error) {
-	return c.apiClient.CompanyWallet.GetApprovals(&apisdk.ParamGetApprovals{
-		Page:   1,
-		Limit:  20,
-		Status: status,
+	return c.GetApprovalsContext(context.Background(), status)
+}
+
+func (c *Client) GetApprovalsContext(ctx context.Context, status string) (*apisdk.RespApprovals, error) {
+	result, _, err := c.getApprovals(ctx, status)
+	return result, err
+}
+
+// 同GetApprovalsContext，complete为false时达到maxPages，还有未查询的分页
+func (c *Client) getApprovals(ctx context.Context, status string) (result *apisdk.RespApprovals, complete bool, err error) {
+	limit := c.pageSize()
+	result = &apisdk.RespApprovals{Page: 1, Limit: limit}
+	seen := map[string]bool{}
+	for page := 1; page <= maxPages; page++ {
+		resp, err := c.GetApprovalsPageContext(ctx, status, page, limit)
+		if err != nil {
+			return nil, false, err
+		}
+		added := 0
+		for _, appr := range resp.Data {
+			if seen[appr.RecordId] {
+				continue
+			}
+			seen[appr.RecordId] = true
+			result.Data = append(result.Data, appr)
+			added++
+		}
+		if len(resp.Data) == 0 || added == 0 { //服务端可能限制每页数量，只在空页或没有新记录时结束
+			return result, true, nil
+		}
+	}
+	return result, false, nil
+}
+
+// 查询单页审批列表
+func (c *Client) GetApprovalsPage(status string, page, limit int) (*apisdk.RespApprovals, error) {
+	return c.GetApprovalsPageContext(context.Background(), status, page, limit)
+}
+
+func (c *Client) GetApprovalsPageContext(ctx context.Context, status string, page, limit int) (*apisdk.RespApprovals, error) {
+	var resp *apisdk.RespApprovals
+	err := c.RetryPolicy.do(ctx, IsRetryable, func() (err error) {
+		resp, err = c.apiClient.GetApprovals(ctx, &apisdk.ParamGetApprovals{
+			Page:   page,
+			Limit:  limit,
+			Status: status,
+		})
+		return err
 	})
+	return resp, err
 }
 
+// 遍历所有分页查询发起的审批，按recordId去重
 func (c *Client) GetSponsoredApprovals(recordId string) (*apisdk.RespApprovalsV2, error) {
-	return c.apiClient.CompanyWallet.GetApprovalsV2(&apisdk.ParamGetApprovalsV2{
-		Page:     1,
-		Limit:    20,
-		ListType: "sponsor",
-		RecordID: recordId,
+	return c.GetSponsoredApprovalsContext(context.Background(), recordId)
+}
+
+func (c *Client) GetSponsoredApprovalsContext(ctx context.Context, recordId string) (*apisdk.RespApprovalsV2, error) {
+	result, _, _, err := c.getSponsoredApprovals(ctx, recordId, false)
+	return result, err
+}
+
+/*
+  - 同GetSponsoredApprovalsContext
+    @withNotes: 同时查询审批的备注，OpenBlockApi实现GetApprovalsV2Notes时返回备注，否则notes为空
+    返回值: complete为false时达到maxPages，还有未查询的分页
+*/
+func (c *Client) getSponsoredApprovals(ctx context.Context, recordId string, withNotes bool) (result *apisdk.RespApprovalsV2, notes map[string]string, complete bool, err error) {
+	limit := c.pageSize()
+	result = &apisdk.RespApprovalsV2{Ok: true}
+	result.Data.Page = 1
+	result.Data.Limit = limit
+	notes = map[string]string{}
+	seen := map[string]bool{}
+	for page := 1; page <= maxPages; page++ {
+		var resp *apisdk.RespApprovalsV2
+		if withNotes {
+			var pageNotes map[string]string
+			resp, pageNotes, err = c.getSponsoredApprovalsPageNotes(ctx, recordId, page, limit)
+			for id, note := range pageNotes {
+				notes[id] = note
+			}
+		} else {
+			resp, err = c.GetSponsoredApprovalsPageContext(ctx, recordId, page, limit)
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+		result.Data.IngCount = resp.Data.IngCount
+		result.Data.SponsorCount = resp.Data.SponsorCount
+		result.Data.FinishCount = resp.Data.FinishCount
+		added := 0
+		for _, appr := range resp.Data.Data {
+			if seen[appr.RecordID] {
+				continue
+			}
+			seen[appr.RecordID] = true
+			result.Data.Data = append(result.Data.Data, appr)
+			added++
+		}
+		if len(resp.Data.Data) == 0 || added == 0 { //服务端可能限制每页数量，只在空页或没有新记录时结束
+			return result, notes, true, nil
+		}
+	}
+	return result, notes, false, nil
+}
+
+// 查询单页发起的审批
+func (c *Client) GetSponsoredApprovalsPage(recordId string, page, limit int) (*apisdk.RespApprovalsV2, error) {
+	return c.GetSponsoredApprovalsPageContext(context.Background(), recordId, page, limit)
+}
+
+func (c *Client) GetSponsoredApprovalsPageContext(ctx context.Context, recordId string, page, limit int) (*apisdk.RespApprovalsV2, error) {
+	var resp *apisdk.RespApprovalsV2
+	err := c.RetryPolicy.do(ctx, IsRetryable, func() (err error) {
+		resp, err = c.apiClient.GetApprovalsV2(ctx, &apisdk.ParamGetApprovalsV2{
+			Page:     page,
+			Limit:    limit,
+			ListType: "sponsor",
+			RecordID: recordId,
+		})
+		return err
+	})
+	return resp, err
+}
+
+// 查询发起的审批时同时返回备注，apisdk.RespApprovalsV2不包含note，httpApi和approvaltest.FakeApi实现了该方法
+type approvalNotesApi interface {
+	GetApprovalsV2Notes(ctx context.Context, params *apisdk.ParamGetApprovalsV2) (*apisdk.RespApprovalsV2, map[string]string, error)
+}
+
+// 查询单页发起的审批和备注，OpenBlockApi未实现GetApprovalsV2Notes时备注为空
+func (c *Client) getSponsoredApprovalsPageNotes(ctx context.Context, recordId string, page, limit int) (*apisdk.RespApprovalsV2, map[string]string, error) {
+	api, ok := c.apiClient.(approvalNotesApi)
+	if !ok {
+		resp, err := c.GetSponsoredApprovalsPageContext(ctx, recordId, page, limit)
+		return resp, nil, err
+	}
+	var resp *apisdk.RespApprovalsV2
+	var notes map[string]string
+	err := c.RetryPolicy.do(ctx, IsRetryable, func() (err error) {
+		resp, notes, err = api.GetApprovalsV2Notes(ctx, &apisdk.ParamGetApprovalsV2{
+			Page:     page,
+			Limit:    limit,
+			ListType: "sponsor",
+			RecordID: recordId,
+		})
+		return err
 	})
+	return resp, notes, err
+}
+
+func (c *Client) pageSize() int {
+	if c.PageSize > 0 {
+		return c.PageSize
+	}
+	return defaultPageSize
 }
 
 func (c *Client) AggreeApproval(approvalId string, agree bool) (*apisdk.RespAgreeApproval, error) {
-	agreeStr := "reject"
+	return c.AggreeApprovalContext(context.Background(), approvalId, agree)
+}
+
+func (c *Client) AggreeApprovalContext(ctx context.Context, approvalId string, agree bool) (*apisdk.RespAgreeApproval, error) {
+	agreeStr, expected := "reject", "REJECT"
 	if agree {
-		agreeStr = "agree"
+		agreeStr, expected = "agree", "AGREE"
 	}
-	return c.apiClient.CompanyWallet.AgreeApproval(&apisdk.ParamAgreeApproval{
-		RecordID: approvalId,
-		Agree:    agreeStr,
+	var resp *apisdk.RespAgreeApproval
+	noRetry := false
+	err := c.RetryPolicy.do(ctx, func(err error) bool { return !noRetry && IsRetryable(err) }, func() (err error) {
+		resp, err = c.apiClient.AgreeApproval(ctx, &apisdk.ParamAgreeApproval{
+			RecordID: approvalId,
+			Agree:    agreeStr,
+		})
+		if err == nil || !IsRetryable(err) || isRateLimit(err) {
+			return err
+		}
+		// 临时错误时审批可能已经生效，重新查询审批状态后再决定是否重试
+		status, statusErr := c.approvalStatus(ctx, approvalId)
+		switch {
+		case statusErr != nil: //无法确认审批结果，不重试，返回临时错误
+			log.Printf("query approval status failed, recordId: %s, %v", approvalId, statusErr)
+			noRetry = true
+			return err
+		case status == expected:
+			resp = &apisdk.RespAgreeApproval{Ok: true}
+			resp.Data.RecordId = approvalId
+			return nil
+		case status == "ING":
+			return err
+		default:
+			return &ApiError{Kind: ErrorPermanent, Message: fmt.Sprintf("approval %s status is %s after %v", approvalId, status, err)}
+		}
+	})
+	return resp, err
+}
+
+// 按id查询单条审批的状态，查询不到时无法确认审批结果，返回临时错误
+func (c *Client) approvalStatus(ctx context.Context, approvalId string) (string, error) {
+	var resp *apisdk.RespApprovalsV2
+	err := c.RetryPolicy.do(ctx, IsRetryable, func() (err error) {
+		resp, err = c.apiClient.GetApprovalsV2(ctx, &apisdk.ParamGetApprovalsV2{
+			Page:     1,
+			Limit:    1,
+			RecordID: approvalId,
+		})
+		return err
 	})
+	if err != nil {
+		return "", err
+	}
+	for _, appr := range resp.Data.Data {
+		if appr.RecordID == approvalId {
+			return appr.Status, nil
+		}
+	}
+	return "", &ApiError{Kind: ErrorTransient, Message: fmt.Sprintf("approval %s not found", approvalId)}
 }
 
 func (c *Client) NewApproval(hdWalletId, action string, txInfo *apisdk.TXInfo, note string, expiredSeconds int32) (*apisdk.RespNewApproval, error) {
+	return c.NewApprovalContext(context.Background(), hdWalletId, action, txInfo, note, expiredSeconds)
+}
+
+func (c *Client) NewApprovalContext(ctx context.Context, hdWalletId, action string, txInfo *apisdk.TXInfo, note string, expiredSeconds int32) (*apisdk.RespNewApproval, error) {
 	txInfoJson, _ := json.Marshal(txInfo)
-	log.Printf("NewApproval, hdWalletId: %s, action: %s, txInfo: %s, expiredSec: %d", hdWalletId, action, string(txInfoJson), expiredSeconds)
-	return c.apiClient.CompanyWallet.NewApproval(&apisdk.ParamNewApproval{
-		HDWalletID:     hdWalletId,
-		Action:         action,
-		TXInfo:         *txInfo,
-		Note:           note,
-		ExpiredTimeout: expiredSeconds,
+	log.Printf("NewApproval, hdWalletId: %s, action: %s, note: %s, txInfo: %s, expiredSec: %d", hdWalletId, action, note, string(txInfoJson), expiredSeconds)
+	// 临时错误时请求可能已经创建了审批，只在频率限制时重试，避免重复发起
+	var resp *apisdk.RespNewApproval
+	err := c.RetryPolicy.do(ctx, isRateLimit, func() (err error) {
+		resp, err = c.apiClient.NewApproval(ctx, &apisdk.ParamNewApproval{
+			HDWalletID:     hdWalletId,
+			Action:         action,
+			TXInfo:         *txInfo,
+			Note:           note,
+			ExpiredTimeout: expiredSeconds,
+		})
+		return err
 	})
+	return resp, err
 }
 
 func (c *Client) GetWalletInfo() (*[]*WalletInfo, error) {
+	return c.GetWalletInfoContext(context.Background())
+}
+
+func (c *Client) GetWalletInfoContext(ctx context.Context) (*[]*WalletInfo, error) {
 	var walletInfos []*WalletInfo
-	mainWalletInfo, err := c.GetHDWalletInfo("-")
+	mainWalletInfo, err := c.GetHDWalletInfoContext(ctx, "-")
 	if err != nil {
 		return nil, err
 	}
 	walletInfos = append(walletInfos, mainWalletInfo)
 
 	for _, hdWallet := range mainWalletInfo.HDWalletList {
-		walletInfo, err := c.GetHDWalletInfo(hdWallet.WalletId)
+		walletInfo, err := c.GetHDWalletInfoContext(ctx, hdWallet.WalletId)
 		if err != nil {
 			return nil, err
 		}
 
 		walletInfo.WalletName = hdWallet.WalletName
 		walletInfos = append(walletInfos, walletInfo)
-		time.Sleep(1 * time.Second)
+		if err := sleepContext(ctx, 1*time.Second); err != nil {
+			return nil, err
+		}
 	}
 	return &walletInfos, nil
 }
 
 func (c *Client) GetHDWalletInfo(hdWalletId string) (*WalletInfo, error) {
+	return c.GetHDWalletInfoContext(context.Background(), hdWalletId)
+}
+
+func (c *Client) GetHDWalletInfoContext(ctx context.Context, hdWalletId string) (*WalletInfo, error) {
 	if walletInfo, ok := c.WalletInfoMap[hdWalletId]; ok && walletInfo != nil {
 		return walletInfo, nil
 	}
@@ -107,7 +352,11 @@ func (c *Client) GetHDWalletInfo(hdWalletId string) (*WalletInfo, error) {
 	}
 	if hdWalletId == "-" {
 		walletInfo.IsHDWallet = false
-		resp, err := c.apiClient.CompanyWallet.GetCompanyWalletInfo()
+		var resp *apisdk.RespGetCompanyWalletInfo
+		err := c.RetryPolicy.do(ctx, IsRetryable, func() (err error) {
+			resp, err = c.apiClient.GetCompanyWalletInfo(ctx)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -126,8 +375,12 @@ func (c *Client) GetHDWalletInfo(hdWalletId string) (*WalletInfo, error) {
 		}
 
 	} else {
-		resp, err := c.apiClient.CompanyWallet.GetCompanyWalletHDWalletAddress(&apisdk.ParamGetCompanyWalletHDWalletAddress{
-			HDWalletID: hdWalletId,
+		var resp *apisdk.RespGetCompanyWalletHDWalletAddress
+		err := c.RetryPolicy.do(ctx, IsRetryable, func() (err error) {
+			resp, err = c.apiClient.GetCompanyWalletHDWalletAddress(ctx, &apisdk.ParamGetCompanyWalletHDWalletAddress{
+				HDWalletID: hdWalletId,
+			})
+			return err
 		})
 		if err != nil {
 			return nil, err
@@ -140,3 +393,15 @@ func (c *Client) GetHDWalletInfo(hdWalletId string) (*WalletInfo, error) {
 	c.WalletInfoMap[hdWalletId] = &walletInfo
 	return &walletInfo, nil
 }
+
+// 等待d时间，ctx结束时提前返回
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
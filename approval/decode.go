@@ -0,0 +1,36 @@
+package approval
+
+import "strings"
+
+// 解码后的字段保存在txInfo的decoded路径下，如 decoded.method、decoded.args.to
+const decodedKey = "decoded"
+
+// 解码txInfo中的交易数据，结果写入txInfo[decodedKey]，无法解码时不修改txInfo
+// EVM calldata使用resources中注册的合约ABI解码
+func addDecoded(resources *PolicyResources, txInfo map[string]interface{}) {
+	if txInfo == nil {
+		return
+	}
+	chain, _ := txInfo["chain"].(string)
+	var decoded map[string]interface{}
+	switch {
+	case isEvmChain(chain):
+		data, _ := txInfo["data"].(string)
+		to, _ := txInfo["to"].(string)
+		decoded = decodeEvmCalldata(resources, data, to)
+	case strings.EqualFold(chain, SOLANA):
+		decoded = decodeSolanaPayload(txInfo["txPayload"])
+	}
+	if decoded != nil {
+		txInfo[decodedKey] = decoded
+	}
+}
+
+func isEvmChain(chain string) bool {
+	for _, c := range []string{ETHEREUM, BSC, POLYGON, ARBITRUM, OPTIMISM, AVALANCHE, FANTOM} {
+		if strings.EqualFold(chain, c) {
+			return true
+		}
+	}
+	return false
+}
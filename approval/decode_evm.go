@@ -0,0 +1,203 @@
+package approval
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// 内置的常用合约方法：ERC-20、ERC-721、ERC-1155和multicall
+const builtinEvmABI = `[
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"transferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"safeTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"tokenId","type":"uint256"}]},
+	{"type":"function","name":"safeTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"tokenId","type":"uint256"},{"name":"data","type":"bytes"}]},
+	{"type":"function","name":"setApprovalForAll","inputs":[{"name":"operator","type":"address"},{"name":"approved","type":"bool"}]},
+	{"type":"function","name":"safeTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"id","type":"uint256"},{"name":"amount","type":"uint256"},{"name":"data","type":"bytes"}]},
+	{"type":"function","name":"safeBatchTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"ids","type":"uint256[]"},{"name":"amounts","type":"uint256[]"},{"name":"data","type":"bytes"}]},
+	{"type":"function","name":"multicall","inputs":[{"name":"data","type":"bytes[]"}]},
+	{"type":"function","name":"multicall","inputs":[{"name":"deadline","type":"uint256"},{"name":"data","type":"bytes[]"}]},
+	{"type":"function","name":"aggregate3","inputs":[{"name":"calls","type":"tuple[]","components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}]}
+]`
+
+// multicall嵌套解码的最大深度
+const maxCalldataDepth = 3
+
+// 内置ABI解析后的合约方法，builtinEvmABI在测试中校验
+var builtinEvmMethods = sync.OnceValue(func() map[[4]byte]abi.Method {
+	methods := map[[4]byte]abi.Method{}
+	parsed, err := abi.JSON(strings.NewReader(builtinEvmABI))
+	if err != nil {
+		return methods
+	}
+	for _, method := range parsed.Methods {
+		methods[[4]byte(method.ID)] = method
+	}
+	return methods
+})
+
+/*
+  - 注册合约ABI，用于解码EVM交易的calldata
+    与已注册方法的selector相同时覆盖原方法
+    @abiJson: 标准ABI json
+*/
+func (r *PolicyResources) RegisterABI(abiJson []byte) error {
+	parsed, err := abi.JSON(bytes.NewReader(abiJson))
+	if err != nil {
+		return err
+	}
+	for _, method := range parsed.Methods {
+		r.methods[[4]byte(method.ID)] = method
+	}
+	r.sources = append(r.sources, "abi:"+string(abiJson))
+	return nil
+}
+
+// 从文件注册合约ABI，支持标准ABI数组或包含abi字段的编译产物（如hardhat/foundry输出）
+func (r *PolicyResources) RegisterABIFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if artifact := bytes.TrimSpace(data); len(artifact) > 0 && artifact[0] == '{' {
+		var wrapper struct {
+			Abi json.RawMessage `json:"abi"`
+		}
+		if err := json.Unmarshal(artifact, &wrapper); err != nil {
+			return fmt.Errorf("abi file %s: %w", path, err)
+		}
+		data = wrapper.Abi
+	}
+	if err := r.RegisterABI(data); err != nil {
+		return fmt.Errorf("abi file %s: %w", path, err)
+	}
+	return nil
+}
+
+/*
+  - 解码EVM calldata
+    返回值: selector、method、signature、args，multicall类方法会解码嵌套调用到calls中
+    data不是有效的calldata时返回nil，方法未注册时只返回selector
+*/
+func decodeEvmCalldata(resources *PolicyResources, data, to string) map[string]interface{} {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(data, "0x"), "0X"))
+	if err != nil || len(raw) < 4 {
+		return nil
+	}
+	return resources.decodeEvmCall(raw, strings.ToLower(to), 0)
+}
+
+func (r *PolicyResources) decodeEvmCall(raw []byte, target string, depth int) map[string]interface{} {
+	decoded := map[string]interface{}{
+		"selector": hexutil.Encode(raw[:4]),
+	}
+	if target != "" {
+		decoded["target"] = target
+	}
+	method, ok := r.methods[[4]byte(raw[:4])]
+	if !ok {
+		return decoded
+	}
+	values, err := method.Inputs.Unpack(raw[4:])
+	if err != nil {
+		decoded["error"] = err.Error()
+		return decoded
+	}
+
+	args := map[string]interface{}{}
+	for i, input := range method.Inputs {
+		name := input.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		args[name] = convertAbiValue(input.Type, values[i])
+	}
+	decoded["method"] = method.RawName
+	decoded["signature"] = method.Sig
+	decoded["args"] = args
+
+	if depth < maxCalldataDepth {
+		if calls := r.decodeNestedCalls(method, values, target, depth+1); calls != nil {
+			decoded["calls"] = calls
+		}
+	}
+	return decoded
+}
+
+// 解码multicall(bytes[])、multicall(uint256,bytes[])、aggregate3中的子调用
+func (r *PolicyResources) decodeNestedCalls(method abi.Method, values []interface{}, target string, depth int) []interface{} {
+	var calls []interface{}
+	switch method.Sig {
+	case "multicall(bytes[])", "multicall(uint256,bytes[])":
+		for _, data := range values[len(values)-1].([][]byte) {
+			calls = append(calls, r.decodeNestedCall(data, target, depth))
+		}
+	case "aggregate3((address,bool,bytes)[])":
+		items := reflect.ValueOf(values[0])
+		for i := 0; i < items.Len(); i++ {
+			item := items.Index(i)
+			callTarget := strings.ToLower(item.Field(0).Interface().(common.Address).Hex())
+			calls = append(calls, r.decodeNestedCall(item.Field(2).Bytes(), callTarget, depth))
+		}
+	}
+	return calls
+}
+
+func (r *PolicyResources) decodeNestedCall(data []byte, target string, depth int) interface{} {
+	if len(data) < 4 {
+		return map[string]interface{}{"target": target, "data": hexutil.Encode(data)}
+	}
+	return r.decodeEvmCall(data, target, depth)
+}
+
+// 将abi解码结果转换为getValueByPath和规则可以处理的类型：字符串、列表和map
+// 地址转为小写，整数转为十进制字符串，bytes转为0x开头的hex
+func convertAbiValue(t abi.Type, v interface{}) interface{} {
+	switch t.T {
+	case abi.AddressTy:
+		return strings.ToLower(v.(common.Address).Hex())
+	case abi.IntTy, abi.UintTy, abi.BoolTy, abi.StringTy:
+		if n, ok := v.(*big.Int); ok {
+			return n.String()
+		}
+		return fmt.Sprintf("%v", v)
+	case abi.BytesTy:
+		return hexutil.Encode(v.([]byte))
+	case abi.FixedBytesTy, abi.HashTy, abi.FunctionTy:
+		rv := reflect.ValueOf(v)
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return hexutil.Encode(b)
+	case abi.SliceTy, abi.ArrayTy:
+		rv := reflect.ValueOf(v)
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = convertAbiValue(*t.Elem, rv.Index(i).Interface())
+		}
+		return list
+	case abi.TupleTy:
+		rv := reflect.ValueOf(v)
+		m := map[string]interface{}{}
+		for i, elem := range t.TupleElems {
+			name := t.TupleRawNames[i]
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			m[name] = convertAbiValue(*elem, rv.Field(i).Interface())
+		}
+		return m
+	}
+	return fmt.Sprintf("%v", v)
+}
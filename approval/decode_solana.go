@@ -0,0 +1,285 @@
+package approval
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+
+	solana "github.com/gagliardetto/solana-go"
+)
+
+var solanaPrograms = map[string]string{
+	solana.SystemProgramID.String():                    "system",
+	solana.TokenProgramID.String():                     "spl-token",
+	solana.Token2022ProgramID.String():                 "token-2022",
+	solana.ComputeBudget.String():                      "compute-budget",
+	solana.SPLAssociatedTokenAccountProgramID.String(): "associated-token-account",
+	solana.MemoProgramID.String():                      "memo",
+}
+
+/*
+  - 解码Solana交易的compiledInstructions
+    通过staticAccountKeys解析每条指令的programId和账户，解码常用程序的指令参数
+    返回值: instructions列表和programIds，payload不是BuildTxInfo生成的格式时返回nil
+*/
+func decodeSolanaPayload(payload interface{}) map[string]interface{} {
+	txs, ok := payload.([]interface{})
+	if !ok {
+		return nil
+	}
+	instructions := []interface{}{}
+	programIds := []interface{}{}
+	seen := map[string]bool{}
+	for _, tx := range txs {
+		m, ok := tx.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		keys, _ := m["staticAccountKeys"].([]interface{})
+		compiled, _ := m["compiledInstructions"].([]interface{})
+		var decoded []map[string]interface{}
+		for _, ix := range compiled {
+			ixMap, ok := ix.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			d := decodeSolanaInstruction(ixMap, keys)
+			decoded = append(decoded, d)
+			if programId := d["programId"].(string); !seen[programId] {
+				seen[programId] = true
+				programIds = append(programIds, programId)
+			}
+		}
+		resolveDestinationOwners(decoded)
+		for _, d := range decoded {
+			instructions = append(instructions, d)
+		}
+	}
+	if len(instructions) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"instructions": instructions,
+		"programIds":   programIds,
+	}
+}
+
+// 解析单条指令，地址表中的账户无法解析时为空字符串
+func decodeSolanaInstruction(ix map[string]interface{}, keys []interface{}) map[string]interface{} {
+	account := func(index interface{}) string {
+		i, ok := index.(float64)
+		if !ok || int(i) < 0 || int(i) >= len(keys) {
+			return ""
+		}
+		key, _ := keys[int(i)].(string)
+		return key
+	}
+
+	programId := account(ix["programIdIndex"])
+	indexes, _ := ix["accountKeyIndexes"].([]interface{})
+	accounts := make([]string, len(indexes))
+	accountList := make([]interface{}, len(indexes))
+	for i, index := range indexes {
+		accounts[i] = account(index)
+		accountList[i] = accounts[i]
+	}
+	dataStr, _ := ix["data"].(string)
+	data, _ := base64.StdEncoding.DecodeString(dataStr)
+
+	decoded := map[string]interface{}{
+		"programId": programId,
+		"program":   "unknown",
+		"accounts":  accountList,
+		"data":      dataStr,
+	}
+	program, ok := solanaPrograms[programId]
+	if !ok {
+		return decoded
+	}
+	decoded["program"] = program
+
+	var method string
+	var args map[string]interface{}
+	switch program {
+	case "system":
+		method, args = decodeSystemInstruction(data, accounts)
+	case "spl-token", "token-2022":
+		method, args = decodeTokenInstruction(data, accounts)
+	case "compute-budget":
+		method, args = decodeComputeBudgetInstruction(data)
+	case "associated-token-account":
+		method, args = decodeAssociatedTokenInstruction(data, accounts)
+	case "memo":
+		method, args = "memo", map[string]interface{}{"memo": string(data)}
+	}
+	if method != "" {
+		decoded["method"] = method
+		decoded["args"] = args
+	}
+	return decoded
+}
+
+func decodeSystemInstruction(data []byte, accounts []string) (string, map[string]interface{}) {
+	if len(data) < 4 {
+		return "", nil
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case 0:
+		if len(data) < 52 || len(accounts) < 2 {
+			return "", nil
+		}
+		return "createAccount", map[string]interface{}{
+			"from":       accounts[0],
+			"newAccount": accounts[1],
+			"lamports":   u64String(data[4:]),
+			"space":      u64String(data[12:]),
+			"owner":      solana.PublicKeyFromBytes(data[20:52]).String(),
+		}
+	case 2:
+		if len(data) < 12 || len(accounts) < 2 {
+			return "", nil
+		}
+		return "transfer", map[string]interface{}{
+			"from":     accounts[0],
+			"to":       accounts[1],
+			"lamports": u64String(data[4:]),
+		}
+	}
+	return "", nil
+}
+
+// SPL Token和Token-2022的transfer/transferChecked/approve/approveChecked
+func decodeTokenInstruction(data []byte, accounts []string) (string, map[string]interface{}) {
+	if len(data) < 9 {
+		return "", nil
+	}
+	amount := u64String(data[1:])
+	switch data[0] {
+	case 3:
+		if len(accounts) < 3 {
+			return "", nil
+		}
+		return "transfer", map[string]interface{}{
+			"source":      accounts[0],
+			"destination": accounts[1],
+			"authority":   accounts[2],
+			"amount":      amount,
+		}
+	case 4:
+		if len(accounts) < 3 {
+			return "", nil
+		}
+		return "approve", map[string]interface{}{
+			"source":    accounts[0],
+			"delegate":  accounts[1],
+			"authority": accounts[2],
+			"amount":    amount,
+		}
+	case 12:
+		if len(data) < 10 || len(accounts) < 4 {
+			return "", nil
+		}
+		return "transferChecked", map[string]interface{}{
+			"source":      accounts[0],
+			"mint":        accounts[1],
+			"destination": accounts[2],
+			"authority":   accounts[3],
+			"amount":      amount,
+			"decimals":    strconv.Itoa(int(data[9])),
+		}
+	case 13:
+		if len(data) < 10 || len(accounts) < 4 {
+			return "", nil
+		}
+		return "approveChecked", map[string]interface{}{
+			"source":    accounts[0],
+			"mint":      accounts[1],
+			"delegate":  accounts[2],
+			"authority": accounts[3],
+			"amount":    amount,
+			"decimals":  strconv.Itoa(int(data[9])),
+		}
+	}
+	return "", nil
+}
+
+func decodeComputeBudgetInstruction(data []byte) (string, map[string]interface{}) {
+	if len(data) < 1 {
+		return "", nil
+	}
+	switch data[0] {
+	case 1:
+		if len(data) < 5 {
+			return "", nil
+		}
+		return "requestHeapFrame", map[string]interface{}{"bytes": strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data[1:])), 10)}
+	case 2:
+		if len(data) < 5 {
+			return "", nil
+		}
+		return "setComputeUnitLimit", map[string]interface{}{"units": strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data[1:])), 10)}
+	case 3:
+		if len(data) < 9 {
+			return "", nil
+		}
+		return "setComputeUnitPrice", map[string]interface{}{"microLamports": u64String(data[1:])}
+	}
+	return "", nil
+}
+
+// 创建关联token账户，data为空或0时为create，1为createIdempotent
+func decodeAssociatedTokenInstruction(data []byte, accounts []string) (string, map[string]interface{}) {
+	if len(accounts) < 6 {
+		return "", nil
+	}
+	method := "create"
+	if len(data) > 0 {
+		switch data[0] {
+		case 0:
+		case 1:
+			method = "createIdempotent"
+		default:
+			return "", nil
+		}
+	}
+	return method, map[string]interface{}{
+		"payer":           accounts[0],
+		"associatedToken": accounts[1],
+		"owner":           accounts[2],
+		"mint":            accounts[3],
+		"tokenProgram":    accounts[5],
+	}
+}
+
+/*
+  - token转账的destination是token账户，同一交易中创建了该关联token账户时，设置destinationOwner为钱包地址
+    交易本身不包含已存在token账户的owner，解码时不查询链上数据，转账到已存在的token账户时没有destinationOwner
+    规则中使用destinationOwner时校验规则会输出警告
+*/
+func resolveDestinationOwners(instructions []map[string]interface{}) {
+	owners := map[string]string{}
+	for _, ix := range instructions {
+		if ix["program"] != "associated-token-account" || ix["args"] == nil {
+			continue
+		}
+		args := ix["args"].(map[string]interface{})
+		owners[args["associatedToken"].(string)] = args["owner"].(string)
+	}
+	for _, ix := range instructions {
+		args, ok := ix["args"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		destination, ok := args["destination"].(string)
+		if !ok {
+			continue
+		}
+		if owner, ok := owners[destination]; ok {
+			args["destinationOwner"] = owner
+		}
+	}
+}
+
+func u64String(b []byte) string {
+	return strconv.FormatUint(binary.LittleEndian.Uint64(b), 10)
+}
@@ -0,0 +1,227 @@
+package approval
+
+import (
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	solana "github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func packCalldata(t *testing.T, abiJson, method string, args ...interface{}) []byte {
+	parsed, err := abi.JSON(strings.NewReader(abiJson))
+	assert.NoError(t, err)
+	data, err := parsed.Pack(method, args...)
+	assert.NoError(t, err)
+	return data
+}
+
+func TestBuiltinEvmABI(t *testing.T) {
+	parsed, err := abi.JSON(strings.NewReader(builtinEvmABI))
+	assert.NoError(t, err)
+	assert.Len(t, builtinEvmMethods(), len(parsed.Methods))
+	assert.Len(t, builtinEvmMethods(), 11)
+}
+
+func TestDecodeEvmCalldata(t *testing.T) {
+	to := common.HexToAddress("0xAbCdEf0000000000000000000000000000001234")
+	transfer := packCalldata(t, builtinEvmABI, "transfer", to, big.NewInt(1500000))
+
+	t.Run("ERC20 transfer", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "to": "0xToken", "data": hexutil.Encode(transfer)}
+		addDecoded(NewPolicyResources(), txInfo)
+
+		assert.Equal(t, "transfer", getValueByPath(txInfo, "decoded.method"))
+		assert.Equal(t, "transfer(address,uint256)", getValueByPath(txInfo, "decoded.signature"))
+		assert.Equal(t, "0xa9059cbb", getValueByPath(txInfo, "decoded.selector"))
+		assert.Equal(t, "0xabcdef0000000000000000000000000000001234", getValueByPath(txInfo, "decoded.args.to"))
+		assert.Equal(t, "1500000", getValueByPath(txInfo, "decoded.args.amount"))
+		assert.True(t, CheckParam(txInfo, VerifyParams{Path: "decoded.args.amount", Value: "2000000", Rule: "lte"}))
+	})
+
+	t.Run("ERC1155 批量转账", func(t *testing.T) {
+		data := packCalldata(t, builtinEvmABI, "safeBatchTransferFrom", to, to,
+			[]*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(10), big.NewInt(20)}, []byte{0x01})
+		txInfo := map[string]interface{}{"chain": "Polygon", "data": hexutil.Encode(data)}
+		addDecoded(NewPolicyResources(), txInfo)
+
+		assert.Equal(t, "safeBatchTransferFrom", getValueByPath(txInfo, "decoded.method"))
+		assert.Equal(t, []interface{}{"10", "20"}, getValueByPath(txInfo, "decoded.args.amounts"))
+		assert.Equal(t, "0x01", getValueByPath(txInfo, "decoded.args.data"))
+	})
+
+	t.Run("setApprovalForAll", func(t *testing.T) {
+		data := packCalldata(t, builtinEvmABI, "setApprovalForAll", to, true)
+		txInfo := map[string]interface{}{"chain": "BSC", "data": hexutil.Encode(data)}
+		addDecoded(NewPolicyResources(), txInfo)
+
+		assert.Equal(t, "true", getValueByPath(txInfo, "decoded.args.approved"))
+	})
+
+	t.Run("multicall嵌套调用", func(t *testing.T) {
+		approve := packCalldata(t, builtinEvmABI, "approve", to, big.NewInt(7))
+		data := packCalldata(t, builtinEvmABI, "multicall0", big.NewInt(1700000000), [][]byte{transfer, approve, {0x01}})
+		txInfo := map[string]interface{}{"chain": "ETH", "to": "0xRouter", "data": hexutil.Encode(data)}
+		addDecoded(NewPolicyResources(), txInfo)
+
+		assert.Equal(t, "multicall", getValueByPath(txInfo, "decoded.method"))
+		assert.Equal(t, "1700000000", getValueByPath(txInfo, "decoded.args.deadline"))
+		assert.Equal(t, "transfer", getValueByPath(txInfo, "decoded.calls.0.method"))
+		assert.Equal(t, "0xrouter", getValueByPath(txInfo, "decoded.calls.0.target"))
+		assert.Equal(t, "7", getValueByPath(txInfo, "decoded.calls.1.args.amount"))
+		assert.Equal(t, "0x01", getValueByPath(txInfo, "decoded.calls.2.data"))
+	})
+
+	t.Run("aggregate3", func(t *testing.T) {
+		type call3 struct {
+			Target       common.Address
+			AllowFailure bool
+			CallData     []byte
+		}
+		token := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		data := packCalldata(t, builtinEvmABI, "aggregate3", []call3{{Target: token, CallData: transfer}})
+		txInfo := map[string]interface{}{"chain": "Arbitrum", "data": hexutil.Encode(data)}
+		addDecoded(NewPolicyResources(), txInfo)
+
+		assert.Equal(t, "false", getValueByPath(txInfo, "decoded.args.calls.0.allowFailure"))
+		assert.Equal(t, "0x00000000000000000000000000000000000000aa", getValueByPath(txInfo, "decoded.calls.0.target"))
+		assert.Equal(t, "1500000", getValueByPath(txInfo, "decoded.calls.0.args.amount"))
+	})
+
+	t.Run("未注册的方法只返回selector", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "data": "0x12345678abcd"}
+		addDecoded(NewPolicyResources(), txInfo)
+
+		assert.Equal(t, "0x12345678", getValueByPath(txInfo, "decoded.selector"))
+		assert.Nil(t, getValueByPath(txInfo, "decoded.method"))
+	})
+
+	t.Run("参数错误", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "data": hexutil.Encode(transfer[:20])}
+		addDecoded(NewPolicyResources(), txInfo)
+
+		assert.Nil(t, getValueByPath(txInfo, "decoded.method"))
+		assert.NotNil(t, getValueByPath(txInfo, "decoded.error"))
+	})
+
+	t.Run("非EVM链和无效数据不解码", func(t *testing.T) {
+		for _, txInfo := range []map[string]interface{}{
+			{"chain": "Benfen", "data": hexutil.Encode(transfer)},
+			{"chain": "ETH", "data": "0x"},
+			{"chain": "ETH", "data": "not hex"},
+		} {
+			addDecoded(NewPolicyResources(), txInfo)
+			_, ok := txInfo[decodedKey]
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("自定义ABI文件", func(t *testing.T) {
+		custom := `[{"type":"function","name":"deposit","inputs":[{"name":"vault","type":"address"},{"name":"","type":"uint128"}]}]`
+		path := filepath.Join(t.TempDir(), "vault.json")
+		assert.NoError(t, os.WriteFile(path, []byte(`{"contractName":"Vault","abi":`+custom+`}`), 0644))
+		resources := NewPolicyResources()
+		assert.NoError(t, resources.RegisterABIFile(path))
+
+		data := packCalldata(t, custom, "deposit", to, big.NewInt(42))
+		txInfo := map[string]interface{}{"chain": "ETH", "data": hexutil.Encode(data)}
+		addDecoded(resources, txInfo)
+
+		assert.Equal(t, "deposit", getValueByPath(txInfo, "decoded.method"))
+		assert.Equal(t, "42", getValueByPath(txInfo, "decoded.args.1"))
+
+		// 只对注册的资源生效
+		txInfo = map[string]interface{}{"chain": "ETH", "data": hexutil.Encode(data)}
+		addDecoded(NewPolicyResources(), txInfo)
+		assert.Nil(t, getValueByPath(txInfo, "decoded.method"))
+
+		assert.NoError(t, os.WriteFile(path, []byte(`{"abi": "invalid"}`), 0644))
+		assert.ErrorContains(t, resources.RegisterABIFile(path), path)
+	})
+}
+
+func TestDecodeSolanaInstructions(t *testing.T) {
+	payer := solana.MustPublicKeyFromBase58("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+	receiver := solana.MustPublicKeyFromBase58("7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU")
+	mint := solana.MustPublicKeyFromBase58("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	source, _, _ := solana.FindAssociatedTokenAddress(payer, mint)
+	destination, _, _ := solana.FindAssociatedTokenAddress(receiver, mint)
+
+	u64 := func(prefix []byte, n uint64) []byte {
+		return binary.LittleEndian.AppendUint64(prefix, n)
+	}
+	instructions := []solana.Instruction{
+		solana.NewInstruction(solana.ComputeBudget, nil, binary.LittleEndian.AppendUint32([]byte{2}, 200000)),
+		solana.NewInstruction(solana.ComputeBudget, nil, u64([]byte{3}, 5000)),
+		solana.NewInstruction(solana.SystemProgramID, solana.AccountMetaSlice{
+			solana.Meta(payer).WRITE().SIGNER(), solana.Meta(receiver).WRITE(),
+		}, u64([]byte{2, 0, 0, 0}, 1000000)),
+		solana.NewInstruction(solana.SPLAssociatedTokenAccountProgramID, solana.AccountMetaSlice{
+			solana.Meta(payer).WRITE().SIGNER(), solana.Meta(destination).WRITE(), solana.Meta(receiver),
+			solana.Meta(mint), solana.Meta(solana.SystemProgramID), solana.Meta(solana.TokenProgramID),
+		}, []byte{1}),
+		solana.NewInstruction(solana.TokenProgramID, solana.AccountMetaSlice{
+			solana.Meta(source).WRITE(), solana.Meta(mint), solana.Meta(destination).WRITE(), solana.Meta(payer).SIGNER(),
+		}, append(u64([]byte{12}, 2500000), 6)),
+		solana.NewInstruction(solana.MemoProgramID, nil, []byte("invoice-42")),
+	}
+	tx, err := solana.NewTransaction(instructions, solana.Hash{}, solana.TransactionPayer(payer))
+	assert.NoError(t, err)
+	txData, err := tx.ToBase64()
+	assert.NoError(t, err)
+
+	txInfo, err := BuildTxInfo(SOLANA, txData, false)
+	assert.NoError(t, err)
+	txInfoMap := convertTxInfoToMap(txInfo)
+	addDecoded(NewPolicyResources(), txInfoMap)
+
+	expected := map[string]interface{}{
+		"decoded.instructions.0.method":                "setComputeUnitLimit",
+		"decoded.instructions.0.args.units":            "200000",
+		"decoded.instructions.1.args.microLamports":    "5000",
+		"decoded.instructions.2.program":               "system",
+		"decoded.instructions.2.method":                "transfer",
+		"decoded.instructions.2.args.from":             payer.String(),
+		"decoded.instructions.2.args.to":               receiver.String(),
+		"decoded.instructions.2.args.lamports":         "1000000",
+		"decoded.instructions.3.method":                "createIdempotent",
+		"decoded.instructions.3.args.owner":            receiver.String(),
+		"decoded.instructions.4.program":               "spl-token",
+		"decoded.instructions.4.method":                "transferChecked",
+		"decoded.instructions.4.args.mint":             mint.String(),
+		"decoded.instructions.4.args.amount":           "2500000",
+		"decoded.instructions.4.args.decimals":         "6",
+		"decoded.instructions.4.args.destination":      destination.String(),
+		"decoded.instructions.4.args.destinationOwner": receiver.String(),
+		"decoded.instructions.5.args.memo":             "invoice-42",
+	}
+	for path, value := range expected {
+		assert.Equal(t, value, getValueByPath(txInfoMap, path), path)
+	}
+	assert.True(t, CheckParam(txInfoMap, VerifyParams{Path: "decoded.programIds", Value: solana.TokenProgramID.String(), Rule: "contains"}))
+
+	t.Run("未知程序保留原始数据", func(t *testing.T) {
+		program := solana.MustPublicKeyFromBase58("JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4")
+		tx, err := solana.NewTransaction([]solana.Instruction{
+			solana.NewInstruction(program, solana.AccountMetaSlice{solana.Meta(payer).SIGNER()}, []byte{1, 2, 3}),
+		}, solana.Hash{}, solana.TransactionPayer(payer))
+		assert.NoError(t, err)
+		txData, _ := tx.ToBase64()
+		txInfo, err := BuildTxInfo(SOLANA, txData, false)
+		assert.NoError(t, err)
+		txInfoMap := convertTxInfoToMap(txInfo)
+		addDecoded(NewPolicyResources(), txInfoMap)
+
+		assert.Equal(t, "unknown", getValueByPath(txInfoMap, "decoded.instructions.0.program"))
+		assert.Equal(t, program.String(), getValueByPath(txInfoMap, "decoded.instructions.0.programId"))
+		assert.Equal(t, "AQID", getValueByPath(txInfoMap, "decoded.instructions.0.data"))
+		assert.Nil(t, getValueByPath(txInfoMap, "decoded.instructions.0.method"))
+	})
+}
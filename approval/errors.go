@@ -0,0 +1,147 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 错误类型，用于判断是否需要重试
+type ErrorKind string
+
+const (
+	ErrorTransient ErrorKind = "transient"  // 网络错误、5xx等临时错误，可以重试
+	ErrorRateLimit ErrorKind = "rate_limit" // 请求频率限制，等待后重试
+	ErrorAuth      ErrorKind = "auth"       // api key、签名或权限错误，不重试
+	ErrorPermanent ErrorKind = "permanent"  // 参数、状态等错误，不重试
+)
+
+// openblock接口或docker签名接口返回的错误
+type ApiError struct {
+	Kind       ErrorKind
+	StatusCode int    // http状态码
+	Code       string // 接口返回的错误码
+	Message    string
+	Err        error // 底层错误，如网络错误
+}
+
+func (e *ApiError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s error: %v", e.Kind, e.Err)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("%s error: code=%s msg=%s", e.Kind, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s error: status=%d msg=%s", e.Kind, e.StatusCode, e.Message)
+}
+
+func (e *ApiError) Unwrap() error {
+	return e.Err
+}
+
+// 获取错误类型，非ApiError的错误视为permanent
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return ErrorPermanent
+}
+
+// 是否可以重试
+func IsRetryable(err error) bool {
+	kind := ErrorKindOf(err)
+	return kind == ErrorTransient || kind == ErrorRateLimit
+}
+
+func isRateLimit(err error) bool {
+	return ErrorKindOf(err) == ErrorRateLimit
+}
+
+// 根据http状态码分类错误
+func classifyStatus(statusCode int, message string) *ApiError {
+	kind := ErrorPermanent
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		kind = ErrorRateLimit
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		kind = ErrorAuth
+	case statusCode >= 500 || statusCode == http.StatusRequestTimeout:
+		kind = ErrorTransient
+	}
+	return &ApiError{Kind: kind, StatusCode: statusCode, Message: message}
+}
+
+// 根据接口返回的错误信息分类错误，接口没有公开错误码列表，按错误信息关键字判断
+func classifyErrCode(code, message string) *ApiError {
+	msg := strings.ToLower(message)
+	kind := ErrorPermanent
+	switch {
+	// 临时错误的关键字优先，避免 "sign service busy" 等信息被当作签名错误不再重试
+	case containsAny(msg, "too many", "rate limit", "frequent", "频繁"):
+		kind = ErrorRateLimit
+	case containsAny(msg, "timeout", "busy", "unavailable", "try again", "超时", "繁忙"):
+		kind = ErrorTransient
+	case containsAny(msg, "sign", "api_key", "apikey", "auth", "permission", "签名", "权限"):
+		kind = ErrorAuth
+	}
+	return &ApiError{Kind: kind, StatusCode: http.StatusOK, Code: code, Message: message}
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// 重试策略，使用指数退避
+type RetryPolicy struct {
+	MaxAttempts    int           // 最大尝试次数，小于等于1时不重试
+	InitialBackoff time.Duration // 第一次重试前的等待时间
+	MaxBackoff     time.Duration // 最大等待时间
+	Multiplier     float64       // 每次重试等待时间的倍数
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+}
+
+// 第attempt次重试前的等待时间，attempt从1开始，加入±20%的随机抖动
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	return time.Duration(d * (0.8 + 0.4*rand.Float64()))
+}
+
+// 执行fn，shouldRetry返回true时按策略重试
+func (p RetryPolicy) do(ctx context.Context, shouldRetry func(error) bool, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !shouldRetry(err) {
+			return err
+		}
+		if sleepErr := sleepContext(ctx, p.backoff(attempt)); sleepErr != nil {
+			return err
+		}
+	}
+}
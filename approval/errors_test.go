@@ -0,0 +1,159 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorKind(t *testing.T) {
+	t.Run("http状态码分类", func(t *testing.T) {
+		assert.Equal(t, ErrorRateLimit, classifyStatus(429, "").Kind)
+		assert.Equal(t, ErrorAuth, classifyStatus(401, "").Kind)
+		assert.Equal(t, ErrorAuth, classifyStatus(403, "").Kind)
+		assert.Equal(t, ErrorTransient, classifyStatus(502, "").Kind)
+		assert.Equal(t, ErrorPermanent, classifyStatus(400, "").Kind)
+	})
+
+	t.Run("错误信息分类", func(t *testing.T) {
+		assert.Equal(t, ErrorRateLimit, classifyErrCode("1", "Too many requests").Kind)
+		assert.Equal(t, ErrorAuth, classifyErrCode("1", "invalid sign").Kind)
+		assert.Equal(t, ErrorTransient, classifyErrCode("1", "server busy").Kind)
+		assert.Equal(t, ErrorTransient, classifyErrCode("1", "sign service busy, try again").Kind)
+		assert.Equal(t, ErrorPermanent, classifyErrCode("1", "record not found").Kind)
+	})
+
+	t.Run("包装后的错误", func(t *testing.T) {
+		err := errors.Join(errors.New("other"), &ApiError{Kind: ErrorTransient})
+		assert.Equal(t, ErrorTransient, ErrorKindOf(err))
+		assert.True(t, IsRetryable(err))
+		assert.False(t, IsRetryable(errors.New("plain")))
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Multiplier: 2}
+
+	t.Run("退避时间", func(t *testing.T) {
+		p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+		assert.InDelta(t, float64(100*time.Millisecond), float64(p.backoff(1)), float64(20*time.Millisecond))
+		assert.InDelta(t, float64(400*time.Millisecond), float64(p.backoff(3)), float64(80*time.Millisecond))
+		assert.LessOrEqual(t, p.backoff(10), 1200*time.Millisecond)
+	})
+
+	t.Run("只重试可重试的错误", func(t *testing.T) {
+		calls := 0
+		err := policy.do(context.Background(), IsRetryable, func() error {
+			calls++
+			return &ApiError{Kind: ErrorTransient}
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 3, calls)
+
+		calls = 0
+		err = policy.do(context.Background(), IsRetryable, func() error {
+			calls++
+			return &ApiError{Kind: ErrorAuth}
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("接口临时错误后重试成功", func(t *testing.T) {
+		server := approvaltest.NewServer(nil)
+		defer server.Close()
+		server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Errors: []approvaltest.ScriptError{{Path: "/openapi/company_wallet/approvals/", StatusCode: 502, ErrMsg: "bad gateway", Times: 2}},
+		}))
+		client := NewClient("key", "secret")
+		client.SetApiUrl(server.URL)
+		client.RetryPolicy = policy
+
+		apprs, err := client.GetApprovals("ING")
+		assert.NoError(t, err)
+		assert.Len(t, apprs.Data, 1)
+	})
+
+	t.Run("单条审批失败不影响其他审批", func(t *testing.T) {
+		server := approvaltest.NewServer(nil)
+		defer server.Close()
+		first := server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		second := server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "2"})
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Errors: []approvaltest.ScriptError{{Path: "/openapi/company_wallet/approval/agree/", ErrCode: "40004", ErrMsg: "record locked", Times: 1}},
+		}))
+		client := NewClient("key", "secret")
+		client.SetApiUrl(server.URL)
+		client.RetryPolicy = policy
+
+		params := []ApprovalParams{{MatchParams: []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}}}}
+		results, err := AutoApprove(client, &params)
+		assert.ErrorContains(t, err, "record locked")
+		assert.Equal(t, ErrorPermanent, ErrorKindOf(err))
+		assert.Len(t, results, 2)
+		assert.Error(t, results[0].Err)
+		assert.False(t, results[0].Approved)
+		assert.True(t, results[1].Approved)
+
+		r, _ := server.Api.Record(first)
+		assert.Equal(t, approvaltest.StatusIng, r.Status)
+		r, _ = server.Api.Record(second)
+		assert.Equal(t, approvaltest.StatusAgree, r.Status)
+	})
+
+	t.Run("审批临时错误后查询状态", func(t *testing.T) {
+		timeout := &ApiError{Kind: ErrorTransient, Message: "timeout"}
+
+		// 审批已生效，不重试
+		api := &agreeErrApi{FakeApi: approvaltest.NewFakeApi(), err: timeout, apply: true}
+		id := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		client := NewClientWithApi("key", "secret", api)
+		client.RetryPolicy = policy
+		resp, err := client.AggreeApproval(id, true)
+		assert.NoError(t, err)
+		assert.Equal(t, id, resp.Data.RecordId)
+		assert.Equal(t, 1, api.Calls("AgreeApproval"))
+
+		// 审批已被其他人拒绝，不重试
+		api = &agreeErrApi{FakeApi: approvaltest.NewFakeApi(), err: timeout}
+		id = api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		api.Reject(id)
+		client = NewClientWithApi("key", "secret", api)
+		client.RetryPolicy = policy
+		_, err = client.AggreeApproval(id, true)
+		assert.ErrorContains(t, err, "status is REJECT")
+		assert.Equal(t, ErrorPermanent, ErrorKindOf(err))
+
+		// 查询不到审批时无法确认结果，返回临时错误
+		api = &agreeErrApi{FakeApi: approvaltest.NewFakeApi(), err: timeout}
+		client = NewClientWithApi("key", "secret", api)
+		client.RetryPolicy = policy
+		_, err = client.AggreeApproval("missing", true)
+		assert.ErrorIs(t, err, timeout)
+		assert.Equal(t, ErrorTransient, ErrorKindOf(err))
+		assert.Equal(t, 1, api.Calls("GetApprovalsV2"))
+		assert.Equal(t, 0, api.Calls("GetApprovals"))
+
+		// 仍为待审批时重试
+		server := approvaltest.NewServer(nil)
+		defer server.Close()
+		id = server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Errors: []approvaltest.ScriptError{{Path: "/openapi/company_wallet/approval/agree/", StatusCode: 504, ErrMsg: "gateway timeout", Times: 1}},
+		}))
+		client = NewClient("key", "secret")
+		client.SetApiUrl(server.URL)
+		client.RetryPolicy = policy
+		_, err = client.AggreeApproval(id, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, server.Api.Calls("AgreeApproval"))
+		r, _ := server.Api.Record(id)
+		assert.Equal(t, approvaltest.StatusAgree, r.Status)
+	})
+}
@@ -0,0 +1,215 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 审批状态，ING为待审批，AGREE、REJECT为人工审批的最终状态
+const (
+	StatusIng    = "ING"
+	StatusAgree  = "AGREE"
+	StatusReject = "REJECT"
+)
+
+// 历史审批记录，jsonl归档中每行一条
+type HistoryRecord struct {
+	RecordId   string          `json:"recordId"`
+	Status     string          `json:"status"` // 审批状态，如 AGREE、REJECT
+	ActionType string          `json:"actionType"`
+	HdWalletId string          `json:"hdWalletId,omitempty"`
+	WalletName string          `json:"walletName,omitempty"`
+	CreateTime string          `json:"createTime,omitempty"`
+	Note       string          `json:"note,omitempty"` // 发起审批时的备注
+	TxInfo     json.RawMessage `json:"txInfo"`         // 审批记录中的txinfo
+}
+
+// 导出历史审批时列表超过最大分页数，已查询到的记录仍然写入归档
+var ErrHistoryIncomplete = errors.New("history export is incomplete, approval list exceeds the page limit")
+
+/*
+  - 导出历史审批到本地jsonl归档，已在归档中的记录不重复导出
+    通过GetApprovals查询statuses中每个状态的审批，通过GetApprovalsV2查询发起的审批和备注，忽略待审批(ING)的记录
+    返回值: 新增的记录数，列表超过最大分页数时写入已查询到的记录并返回ErrHistoryIncomplete
+*/
+func ExportHistory(ctx context.Context, client *Client, path string, statuses []string) (int, error) {
+	existing, err := ReadHistory(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	for _, r := range existing {
+		seen[r.RecordId] = true
+	}
+
+	var records []HistoryRecord
+	add := func(r HistoryRecord) {
+		if r.Status == StatusIng || seen[r.RecordId] {
+			return
+		}
+		seen[r.RecordId] = true
+		records = append(records, r)
+	}
+	var incomplete []string
+	for _, status := range statuses {
+		apprs, complete, err := client.getApprovals(ctx, status)
+		if err != nil {
+			return 0, fmt.Errorf("get %s approvals: %w", status, err)
+		}
+		if !complete {
+			incomplete = append(incomplete, status)
+		}
+		for _, appr := range apprs.Data {
+			txInfo, _ := json.Marshal(appr.ExtraData.Txinfo)
+			add(HistoryRecord{
+				RecordId:   appr.RecordId,
+				Status:     appr.Status,
+				ActionType: appr.ActionType,
+				HdWalletId: appr.HDWalletID,
+				WalletName: appr.WalletName,
+				CreateTime: appr.CreateTime,
+				Note:       appr.Note,
+				TxInfo:     txInfo,
+			})
+		}
+	}
+	sponsored, notes, complete, err := client.getSponsoredApprovals(ctx, "", true)
+	if err != nil {
+		return 0, fmt.Errorf("get sponsored approvals: %w", err)
+	}
+	if !complete {
+		incomplete = append(incomplete, "sponsored")
+	}
+	for _, appr := range sponsored.Data.Data {
+		txInfo, _ := json.Marshal(appr.ExtraData.Txinfo)
+		add(HistoryRecord{
+			RecordId:   appr.RecordID,
+			Status:     appr.Status,
+			ActionType: appr.ActionType,
+			HdWalletId: appr.HdWalletID,
+			WalletName: appr.WalletName,
+			CreateTime: appr.CreateTime,
+			Note:       notes[appr.RecordID],
+			TxInfo:     txInfo,
+		})
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return 0, err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if len(incomplete) > 0 {
+		return len(records), fmt.Errorf("%w: %s approvals stopped at %d pages", ErrHistoryIncomplete, strings.Join(incomplete, ","), maxPages)
+	}
+	return len(records), nil
+}
+
+// 读取jsonl归档中的历史审批记录，忽略空行
+func ReadHistory(path string) ([]HistoryRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var r HistoryRecord
+		if err := json.Unmarshal([]byte(text), &r); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// 单条历史审批的回放结果
+type ReplayResult struct {
+	RecordId      string `json:"recordId"`
+	Status        string `json:"status"`        // 人工审批状态
+	HumanDecision string `json:"humanDecision"` // approve/reject，状态不是AGREE/REJECT时为空
+	Decision      string `json:"decision"`      // 新规则的决策
+	MatchedIndex  int    `json:"matchedIndex"`
+	Reason        string `json:"reason,omitempty"`
+	Error         string `json:"error,omitempty"` // txInfo无法解析
+}
+
+// 回放报告，对比新规则和人工审批的决策
+type ReplayReport struct {
+	PolicyVersion string         `json:"policyVersion"`
+	Total         int            `json:"total"`
+	Same          int            `json:"same"`          // 与人工审批一致
+	NewlyApproved []ReplayResult `json:"newlyApproved"` // 人工拒绝，新规则通过
+	NewlyRejected []ReplayResult `json:"newlyRejected"` // 人工通过，新规则拒绝
+	Skipped       []ReplayResult `json:"skipped"`       // 新规则未匹配或转人工审批
+	Ignored       []ReplayResult `json:"ignored"`       // 没有人工审批结果或txInfo无法解析
+}
+
+/*
+  - 使用新规则回放历史审批，对比人工审批的决策
+    不检查VelocityLimits，AGREE为人工通过，REJECT为人工拒绝，其他状态不参与对比
+*/
+func ReplayHistory(policy *Policy, records []HistoryRecord) *ReplayReport {
+	report := &ReplayReport{PolicyVersion: policy.Version, Total: len(records)}
+	for _, r := range records {
+		result := ReplayResult{RecordId: r.RecordId, Status: r.Status, MatchedIndex: -1}
+		switch r.Status {
+		case StatusAgree:
+			result.HumanDecision = DecisionApprove
+		case StatusReject:
+			result.HumanDecision = DecisionReject
+		}
+
+		txInfo, err := parseTxInfo(r.TxInfo)
+		if err != nil {
+			result.Error = err.Error()
+			report.Ignored = append(report.Ignored, result)
+			continue
+		}
+		trace := policy.EvaluateNote(txInfo, r.Note)
+		result.Decision = trace.Decision
+		result.MatchedIndex = trace.MatchedIndex
+		result.Reason = trace.Reason
+
+		switch {
+		case result.HumanDecision == "":
+			report.Ignored = append(report.Ignored, result)
+		case result.Decision == DecisionSkip:
+			report.Skipped = append(report.Skipped, result)
+		case result.Decision == result.HumanDecision:
+			report.Same++
+		case result.Decision == DecisionApprove:
+			report.NewlyApproved = append(report.NewlyApproved, result)
+		default:
+			report.NewlyRejected = append(report.NewlyRejected, result)
+		}
+	}
+	return report
+}
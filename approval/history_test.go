@@ -0,0 +1,119 @@
+package approval
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	api := approvaltest.NewFakeApi()
+	setStatus := func(id, status string) {
+		assert.NoError(t, api.Update(id, func(r *approvaltest.Record) { r.Status = status }))
+	}
+	small := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+	setStatus(small, approvaltest.StatusAgree)
+	large := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "100"})
+	setStatus(large, approvaltest.StatusAgree)
+	rejected := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "2"})
+	setStatus(rejected, approvaltest.StatusReject)
+	api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "3"}) // 待审批，不导出
+	client := NewClientWithApi("key", "secret", api)
+	res, err := client.NewApproval("", "TRANSACTION", &apisdk.TXInfo{Chain: "Solana", Value: "1"}, "INV-1", 0)
+	assert.NoError(t, err)
+	sponsored := res.Data.RecordId
+	setStatus(sponsored, "CANCEL")
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	t.Run("导出历史审批", func(t *testing.T) {
+		added, err := ExportHistory(context.Background(), client, path, []string{StatusAgree, StatusReject})
+		assert.NoError(t, err)
+		assert.Equal(t, 4, added)
+
+		// 再次导出不重复
+		added, err = ExportHistory(context.Background(), client, path, []string{StatusAgree, StatusReject})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, added)
+
+		records, err := ReadHistory(path)
+		assert.NoError(t, err)
+		assert.Len(t, records, 4)
+		assert.Equal(t, small, records[0].RecordId)
+		assert.Equal(t, StatusAgree, records[0].Status)
+		assert.Equal(t, sponsored, records[3].RecordId)
+		assert.Equal(t, "INV-1", records[3].Note)
+	})
+
+	t.Run("通过http接口导出发起的审批备注", func(t *testing.T) {
+		server := approvaltest.NewServer(nil)
+		defer server.Close()
+		client := NewClient("key", "secret")
+		client.SetApiUrl(server.URL)
+		res, err := client.NewApproval("", "TRANSACTION", &apisdk.TXInfo{Chain: "ETH", Value: "1"}, "INV-2", 0)
+		assert.NoError(t, err)
+		assert.NoError(t, server.Api.Agree(res.Data.RecordId))
+
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		added, err := ExportHistory(context.Background(), client, path, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, added)
+		records, err := ReadHistory(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "INV-2", records[0].Note)
+	})
+
+	t.Run("超过最大分页数", func(t *testing.T) {
+		api := approvaltest.NewFakeApi()
+		for i := 0; i <= maxPages; i++ {
+			id := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+			assert.NoError(t, api.Update(id, func(r *approvaltest.Record) { r.Status = approvaltest.StatusAgree }))
+		}
+		client := NewClientWithApi("key", "secret", api)
+		client.PageSize = 1
+
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		added, err := ExportHistory(context.Background(), client, path, []string{StatusAgree})
+		assert.ErrorIs(t, err, ErrHistoryIncomplete)
+		assert.ErrorContains(t, err, "AGREE approvals stopped at 500 pages")
+		assert.Equal(t, maxPages, added)
+		records, err := ReadHistory(path)
+		assert.NoError(t, err)
+		assert.Len(t, records, maxPages)
+	})
+
+	t.Run("回放历史审批", func(t *testing.T) {
+		records, err := ReadHistory(path)
+		assert.NoError(t, err)
+		records = append(records, HistoryRecord{RecordId: "bad", Status: StatusAgree, TxInfo: []byte(`"x"`)})
+		policy := CompilePolicy([]ApprovalParams{{
+			MatchParams:  []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}},
+			VerifyParams: []VerifyParams{{Path: "value", Value: "10", Rule: "lt"}},
+		}})
+
+		report := ReplayHistory(policy, records)
+		assert.Equal(t, policy.Version, report.PolicyVersion)
+		assert.Equal(t, 5, report.Total)
+		assert.Equal(t, 1, report.Same)
+		assert.Len(t, report.NewlyApproved, 1)
+		assert.Equal(t, rejected, report.NewlyApproved[0].RecordId)
+		assert.Len(t, report.NewlyRejected, 1)
+		assert.Equal(t, large, report.NewlyRejected[0].RecordId)
+		assert.Equal(t, "verify param value failed: comparison failed", report.NewlyRejected[0].Reason)
+		assert.Empty(t, report.Skipped)
+		assert.Len(t, report.Ignored, 2)
+		assert.Equal(t, "CANCEL", report.Ignored[0].Status)
+		assert.NotEmpty(t, report.Ignored[1].Error)
+	})
+
+	t.Run("归档格式错误", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "bad.jsonl")
+		assert.NoError(t, os.WriteFile(bad, []byte("{}\n\nnot json\n"), 0644))
+		_, err := ReadHistory(bad)
+		assert.ErrorContains(t, err, "line 3")
+	})
+}
@@ -1,10 +1,15 @@
 package approval
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"strings"
 	"time"
 
@@ -28,22 +33,31 @@ const FANTOM = "Fantom"
 const BENFEN = "Benfen"
 const BENFEN_TESTNET = "BenfenTEST"
 
-func SendApprovalTransaction(client *Client, hdWalletId, chainName, txData string) (string, error) {
+func SendApprovalTransaction(client *Client, hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
+	return SendApprovalTransactionContext(context.Background(), client, hdWalletId, chainName, txData, opts...)
+}
+
+func SendApprovalTransactionContext(ctx context.Context, client *Client, hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
 	txInfo, err := BuildTxInfo(chainName, txData, false)
 	if err != nil {
 		return "", err
 	}
-	return SendApprovalTxInfo(client, hdWalletId, txInfo)
+	return SendApprovalTxInfoContext(ctx, client, hdWalletId, txInfo, opts...)
 }
 
-func SignApprovalTransaction(client *Client, hdWalletId, chainName, txData string) (string, error) {
+func SignApprovalTransaction(client *Client, hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
+	return SignApprovalTransactionContext(context.Background(), client, hdWalletId, chainName, txData, opts...)
+}
+
+func SignApprovalTransactionContext(ctx context.Context, client *Client, hdWalletId, chainName, txData string, opts ...ApprovalOptions) (string, error) {
 	txInfo, err := BuildTxInfo(chainName, txData, true)
 	if err != nil {
 		return "", err
 	}
-	return SendApprovalTxInfo(client, hdWalletId, txInfo)
+	return SendApprovalTxInfoContext(ctx, client, hdWalletId, txInfo, opts...)
 }
 
+// 构造审批的txInfo，EVM链的txData为json或0x开头的RLP编码交易
 func BuildTxInfo(chainName, txData string, onlySign bool) (*apisdk.TXInfo, error) {
 	var txInfo *apisdk.TXInfo
 	switch chainName {
@@ -81,9 +95,16 @@ func BuildTxInfo(chainName, txData string, onlySign bool) (*apisdk.TXInfo, error
 		}
 
 	case ETHEREUM, POLYGON, ARBITRUM, OPTIMISM, AVALANCHE, FANTOM, BSC:
-		txInfo = &apisdk.TXInfo{}
-		if err := json.Unmarshal([]byte(txData), &txInfo); err != nil {
-			return nil, fmt.Errorf("evm txData format error: %s", err)
+		if strings.HasPrefix(txData, "0x") { //RLP编码的交易
+			var err error
+			if txInfo, err = decodeEvmRawTx(chainName, txData); err != nil {
+				return nil, err
+			}
+		} else {
+			txInfo = &apisdk.TXInfo{}
+			if err := json.Unmarshal([]byte(txData), &txInfo); err != nil {
+				return nil, fmt.Errorf("evm txData format error: %s", err)
+			}
 		}
 		txInfo.TransactionType = "native"
 		if onlySign {
@@ -112,14 +133,27 @@ func BuildTxInfo(chainName, txData string, onlySign bool) (*apisdk.TXInfo, error
 	return txInfo, nil
 }
 
-func SignApprovalMessage(client *Client, hdWalletId, chainName, message string) (string, error) {
+func SignApprovalMessage(client *Client, hdWalletId, chainName, message string, opts ...ApprovalOptions) (string, error) {
+	return SignApprovalMessageContext(context.Background(), client, hdWalletId, chainName, message, opts...)
+}
+
+func SignApprovalMessageContext(ctx context.Context, client *Client, hdWalletId, chainName, message string, opts ...ApprovalOptions) (string, error) {
+	txInfo, err := BuildMessageTxInfo(chainName, message)
+	if err != nil {
+		return "", err
+	}
+	return SendApprovalTxInfoContext(ctx, client, hdWalletId, txInfo, opts...)
+}
+
+// 构造消息签名的txInfo，message格式同SignApprovalMessage
+func BuildMessageTxInfo(chainName, message string) (*apisdk.TXInfo, error) {
 	var txInfo *apisdk.TXInfo
 	hrMessage := message
 	switch chainName {
 	case SOLANA:
 		m, err := hex.DecodeString(message)
 		if err != nil {
-			return "", fmt.Errorf("invalid hex message: %s", err)
+			return nil, fmt.Errorf("invalid hex message: %s", err)
 		}
 		hrMessage = string(m)
 
@@ -141,7 +175,7 @@ func SignApprovalMessage(client *Client, hdWalletId, chainName, message string)
 			if message[:2] == "0x" {
 				m, err := hex.DecodeString(message)
 				if err != nil {
-					return "", fmt.Errorf("invalid hex message: %s", err)
+					return nil, fmt.Errorf("invalid hex message: %s", err)
 				}
 				hrMessage = string(m)
 				signMsg = hex.EncodeToString(accounts.TextHash(m))
@@ -152,11 +186,11 @@ func SignApprovalMessage(client *Client, hdWalletId, chainName, message string)
 		} else {
 			var td apitypes.TypedData
 			if err := json.Unmarshal([]byte(message), &td); err != nil {
-				return "", fmt.Errorf("invalid typed message: %s", err)
+				return nil, fmt.Errorf("invalid typed message: %s", err)
 			}
 			hash, _, err := TypedDataAndHash(td)
 			if err != nil {
-				return "", fmt.Errorf("failed to get typed data hash: %s", err)
+				return nil, fmt.Errorf("failed to get typed data hash: %s", err)
 			}
 			signMsg = hex.EncodeToString(hash)
 		}
@@ -174,13 +208,13 @@ func SignApprovalMessage(client *Client, hdWalletId, chainName, message string)
 	case BENFEN, BENFEN_TESTNET:
 		s1, err := hex.DecodeString(message)
 		if err != nil {
-			return "", fmt.Errorf("invalid hex message: %s", err)
+			return nil, fmt.Errorf("invalid hex message: %s", err)
 		}
 		hrMessage = string(s1)
 
 		s2, err := bcs.Marshal(s1)
 		if err != nil {
-			return "", fmt.Errorf("invalid bsc message: %s", err)
+			return nil, fmt.Errorf("invalid bsc message: %s", err)
 		}
 		s3 := append([]byte{3, 0, 0}, s2...)
 		s4 := blake2b.Sum256(s3)
@@ -196,13 +230,64 @@ func SignApprovalMessage(client *Client, hdWalletId, chainName, message string)
 		}
 
 	default:
-		return "", fmt.Errorf("not supported")
+		return nil, fmt.Errorf("not supported")
 	}
 
-	return SendApprovalTxInfo(client, hdWalletId, txInfo)
+	return txInfo, nil
+}
+
+// 发起审批的可选参数，对应NewApproval的参数，为空的字段使用默认值
+type ApprovalOptions struct {
+	Note           string `json:"note,omitempty"`           // 审批备注，如发票号，审批规则中可以通过note路径匹配
+	ExpiredSeconds int32  `json:"expiredSeconds,omitempty"` // 审批过期时间（秒），为0时合约交互和只签名为300秒、其他不过期，小于0时不过期
+	Action         string `json:"action,omitempty"`         // TRANSACTION/TRANSACTION_CONTRACT_INTERACTION/TRANSACTION_SIGNATURE，为空时按txInfo判断
 }
 
-func SendApprovalTxInfo(client *Client, hdWalletId string, txInfo *apisdk.TXInfo) (string, error) {
+// 可选参数最多一个
+func approvalOptions(opts []ApprovalOptions) (ApprovalOptions, error) {
+	switch len(opts) {
+	case 0:
+		return ApprovalOptions{}, nil
+	case 1:
+		return opts[0], nil
+	}
+	return ApprovalOptions{}, fmt.Errorf("at most one ApprovalOptions is allowed, got %d", len(opts))
+}
+
+func SendApprovalTxInfo(client *Client, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	return SendApprovalTxInfoContext(context.Background(), client, hdWalletId, txInfo, opts...)
+}
+
+func SendApprovalTxInfoContext(ctx context.Context, client *Client, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	handle, err := SubmitApprovalTxInfoContext(ctx, client, hdWalletId, txInfo, opts...)
+	if err != nil {
+		return "", err
+	}
+	return WaitApprovalContext(ctx, client, handle, client.WaitOptions)
+}
+
+/*
+  - 发起审批，不等待审批结果
+    返回值: 审批句柄，保存后可以通过WaitApproval或GetApprovalStatus查询结果，进程重启后也可以继续等待
+*/
+func SubmitApprovalTxInfo(client *Client, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	return SubmitApprovalTxInfoContext(context.Background(), client, hdWalletId, txInfo, opts...)
+}
+
+func SubmitApprovalTxInfoContext(ctx context.Context, client *Client, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	o, err := approvalOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	handle, _, err := submitApproval(ctx, client, hdWalletId, txInfo, o)
+	return handle, err
+}
+
+// 发起审批，submitted为是否已经发送了发起审批的请求，未发送时一定没有创建审批
+func submitApproval(ctx context.Context, client *Client, hdWalletId string, txInfo *apisdk.TXInfo, o ApprovalOptions) (handle *ApprovalHandle, submitted bool, err error) {
+	if txInfo == nil || txInfo.Chain == "" {
+		return nil, false, fmt.Errorf("TXInfo.Chain is required")
+	}
 	expiredSeconds := int32(0)
 	action := "TRANSACTION"
 	if strings.HasSuffix(txInfo.BridgeMethod, "_signTransaction") || //只签名不发送交易
@@ -213,79 +298,138 @@ func SendApprovalTxInfo(client *Client, hdWalletId string, txInfo *apisdk.TXInfo
 	} else if txInfo.Msg != nil && txInfo.Msg.SignMsg != "" { //消息签名
 		action = "TRANSACTION_SIGNATURE"
 	}
+	if o.Action != "" {
+		action = o.Action
+	}
+	if o.ExpiredSeconds != 0 {
+		expiredSeconds = max(o.ExpiredSeconds, 0)
+	}
 
-	walletInfo, err := client.GetHDWalletInfo(hdWalletId)
+	walletInfo, err := client.GetHDWalletInfoContext(ctx, hdWalletId)
 	if err != nil {
-		return "", fmt.Errorf("GetHDWalletInfo error: %v", err)
+		return nil, false, fmt.Errorf("GetHDWalletInfo error: %w", err)
 	}
 	txInfo.From = walletInfo.WalletAddressMap[txInfo.Chain]
 
-	appr, err := client.NewApproval(hdWalletId, action, txInfo, "", expiredSeconds)
+	appr, err := client.NewApprovalContext(ctx, hdWalletId, action, txInfo, o.Note, expiredSeconds)
 	if err != nil {
-		return "", fmt.Errorf("NewApproval error: %v", err)
+		return nil, true, fmt.Errorf("NewApproval error: %w", err)
 	}
-	recordId := appr.Data.OriginRecordId
+	return &ApprovalHandle{
+		RecordId:     appr.Data.OriginRecordId,
+		Action:       action,
+		Chain:        txInfo.Chain,
+		BridgeMethod: txInfo.BridgeMethod,
+	}, true, nil
+}
 
-	for i := 0; i < 30; i++ {
-		apprs, err := client.GetSponsoredApprovals(recordId)
-		if err != nil {
-			return "", fmt.Errorf("GetSponsoredApprovals error: %v", err)
-		}
-		for _, appr := range apprs.Data.Data {
-			if appr.RecordID != recordId {
-				continue
-			}
+/*
+  - 发起审批的错误是否能确定没有创建审批
+    认证错误和服务端返回的4xx（请求超时除外）表示请求被拒绝，其他错误（如5xx、网络错误、响应无法解析）时审批可能已经创建
+*/
+func approvalNotCreated(err error) bool {
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Kind == ErrorAuth {
+		return true
+	}
+	return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusRequestTimeout
+}
 
-			switch appr.Status {
-			case "AGREE":
-				res := appr.TxHash
-				if strings.HasSuffix(txInfo.BridgeMethod, "_signTransaction") { //只签名不发送交易
-					if appr.ExtraData.CustomData == "" {
-						return "", fmt.Errorf("customData is empty, recordId: %s", recordId)
-					}
-
-					var resData any
-					var rawTx string
-					json.Unmarshal([]byte(appr.ExtraData.CustomData), &resData)
-					if v, ok := resData.(map[string]any); ok && v["data"] != nil {
-						if vv, ok := v["data"].(string); ok {
-							rawTx = vv
-						} else if vv, ok := v["data"].([]any); ok {
-							rawTx = vv[0].(string)
-						} else {
-							return "", fmt.Errorf("invalid customData, recordId: %s", recordId)
-						}
-					}
-					if rawTx == "" {
-						return "", fmt.Errorf("rawTx is empty, recordId: %s", recordId)
-					}
-
-					switch txInfo.Chain {
-					case BENFEN, BENFEN_TESTNET:
-						var suiTxData []any
-						json.Unmarshal([]byte(rawTx), &suiTxData)
-						if suiTxData == nil || len(suiTxData) != 4 {
-							return "", fmt.Errorf("invalid benfen tx data, recordId: %s", recordId)
-						}
-						res = suiTxData[1].([]any)[0].(string)
-					}
-
-				} else if action == "TRANSACTION_SIGNATURE" && appr.ExtraData.Authorization != nil {
-					res = appr.ExtraData.Authorization.FinalHash
-				}
-				if res == "" {
-					return "", fmt.Errorf("sign result is empty")
-				}
-				return res, nil
+// 幂等key对应的审批在发起过程中中断，无法确定是否已经创建了审批
+var ErrInitiationInterrupted = errors.New("approval initiation was interrupted")
+
+/*
+  - 使用幂等key发起审批，key和recordId保存在client.Store中
+    同一个key再次调用时不重复发起，返回已发起的审批，可以继续等待审批结果
+    发起过程中中断且无法确定是否已创建审批时返回ErrInitiationInterrupted，需要查询已发起的审批后
+    通过Store.PutInitiation指定recordId，或者确认未创建后通过Store.RemoveInitiation删除再重新发起
+    client.Store需要设置存储文件路径，只保存在内存中时进程重启后会重复发起，返回错误
+    @key: 调用方的幂等key，如付款单号，同一个key只能用于相同的txInfo和审批选项
+*/
+func SubmitApprovalTxInfoWithKey(client *Client, key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	return SubmitApprovalTxInfoWithKeyContext(context.Background(), client, key, hdWalletId, txInfo, opts...)
+}
 
-			case "REJECT":
-				return "", fmt.Errorf("approval rejected")
-			}
+func SubmitApprovalTxInfoWithKeyContext(ctx context.Context, client *Client, key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (*ApprovalHandle, error) {
+	if key == "" {
+		return nil, fmt.Errorf("idempotency key is required")
+	}
+	if client.Store.Path() == "" {
+		return nil, fmt.Errorf("idempotency key %s requires a store with a file path, set storePath", key)
+	}
+	o, err := approvalOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	// 先查询钱包地址，查询失败时不保存记录
+	if _, err := client.GetHDWalletInfoContext(ctx, hdWalletId); err != nil {
+		return nil, fmt.Errorf("GetHDWalletInfo error: %w", err)
+	}
+	initiation, created, err := client.Store.reserveInitiation(Initiation{
+		Key:        key,
+		TxInfoHash: txInfoHash(hdWalletId, txInfo, o),
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("save initiation %s: %w", key, err)
+	}
+	if !created {
+		if initiation.TxInfoHash != txInfoHash(hdWalletId, txInfo, o) {
+			return nil, fmt.Errorf("idempotency key %s was used with a different txInfo or approval options", key)
 		}
+		if initiation.Handle == nil {
+			return nil, fmt.Errorf("%w, key: %s, check sponsored approvals created after %s", ErrInitiationInterrupted, key, initiation.CreatedAt.Format(time.DateTime))
+		}
+		log.Printf("approval already initiated, key: %s, recordId: %s", key, initiation.Handle.RecordId)
+		return initiation.Handle, nil
+	}
 
-		time.Sleep(3 * time.Second)
+	handle, submitted, err := submitApproval(ctx, client, hdWalletId, txInfo, o)
+	if err != nil {
+		// 只在确定没有创建审批时删除记录，其他错误时请求可能已经创建了审批，保留记录，避免重复发起
+		if !submitted || approvalNotCreated(err) {
+			client.Store.RemoveInitiation(key)
+		}
+		return nil, err
 	}
-	return "", fmt.Errorf("approve timeout")
+	initiation.Handle = handle
+	if err := client.Store.PutInitiation(initiation); err != nil {
+		return handle, fmt.Errorf("save initiation %s, recordId: %s: %w", key, handle.RecordId, err)
+	}
+	return handle, nil
+}
+
+/*
+  - 使用幂等key发起审批并等待审批结果，同SubmitApprovalTxInfoWithKey
+    进程中断后使用同一个key再次调用时，继续等待已发起的审批
+    返回值: txHash/签名/rawTx
+*/
+func SendApprovalTxInfoWithKey(client *Client, key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	return SendApprovalTxInfoWithKeyContext(context.Background(), client, key, hdWalletId, txInfo, opts...)
+}
+
+func SendApprovalTxInfoWithKeyContext(ctx context.Context, client *Client, key, hdWalletId string, txInfo *apisdk.TXInfo, opts ...ApprovalOptions) (string, error) {
+	handle, err := SubmitApprovalTxInfoWithKeyContext(ctx, client, key, hdWalletId, txInfo, opts...)
+	if err != nil {
+		return "", err
+	}
+	return WaitApprovalContext(ctx, client, handle, client.WaitOptions)
+}
+
+// 钱包、txInfo和审批选项的hash，不包括发起时设置的from
+func txInfoHash(hdWalletId string, txInfo *apisdk.TXInfo, opts ApprovalOptions) string {
+	t := *txInfo
+	t.From = ""
+	data, _ := json.Marshal(struct {
+		HdWalletId string
+		TxInfo     apisdk.TXInfo
+		Options    ApprovalOptions
+	}{hdWalletId, t, opts})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
 }
 
 func ConvertCompiledInstructions(instructions []solana.CompiledInstruction) []map[string]any {
@@ -0,0 +1,170 @@
+package approval
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/shopspring/decimal"
+)
+
+// EVM链的chain id，用于校验交易中的chain id
+var evmChainIds = map[string]int64{
+	ETHEREUM:  1,
+	BSC:       56,
+	POLYGON:   137,
+	ARBITRUM:  42161,
+	OPTIMISM:  10,
+	AVALANCHE: 43114,
+	FANTOM:    250,
+}
+
+// 签名和未签名的legacy交易，未签名时没有v/r/s，EIP-155未签名交易的v为chain id，r、s为0
+type rlpLegacyTx struct {
+	Nonce    uint64
+	GasPrice *big.Int
+	Gas      uint64
+	To       *common.Address `rlp:"nil"`
+	Value    *big.Int
+	Data     []byte
+	V, R, S  *big.Int `rlp:"optional"`
+}
+
+// EIP-2930交易，签名时带v/r/s
+type rlpAccessListTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasPrice   *big.Int
+	Gas        uint64
+	To         *common.Address `rlp:"nil"`
+	Value      *big.Int
+	Data       []byte
+	AccessList types.AccessList
+	V, R, S    *big.Int `rlp:"optional"`
+}
+
+// EIP-1559交易，签名时带v/r/s
+type rlpDynamicFeeTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	Gas        uint64
+	To         *common.Address `rlp:"nil"`
+	Value      *big.Int
+	Data       []byte
+	AccessList types.AccessList
+	V, R, S    *big.Int `rlp:"optional"`
+}
+
+/*
+  - 解析0x开头的RLP编码EVM交易，支持legacy、EIP-2930、EIP-1559的签名和未签名交易
+    value转换为ether，gasPrice、maxFeePerGas、maxPriorityFeePerGas转换为gwei，签名会被忽略
+    校验交易中的chain id是否与chainName一致，没有chain id的legacy交易（EIP-155之前的签名、没有v的未签名交易）
+    无法确认交易所在的链，返回错误，需要使用EIP-155或EIP-2930/EIP-1559交易，不支持创建合约的交易
+*/
+func decodeEvmRawTx(chainName, rawTx string) (*apisdk.TXInfo, error) {
+	data, err := hexutil.Decode(rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw transaction hex: %s", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("raw transaction is empty")
+	}
+
+	var (
+		chainId *big.Int
+		to      *common.Address
+		value   *big.Int
+		input   []byte
+		gas     uint64
+		nonce   uint64
+	)
+	txInfo := &apisdk.TXInfo{}
+	switch {
+	case data[0] >= 0xc0: //legacy交易是RLP列表
+		var tx rlpLegacyTx
+		if err := rlp.DecodeBytes(data, &tx); err != nil {
+			return nil, fmt.Errorf("invalid legacy transaction: %s", err)
+		}
+		chainId = legacyChainId(tx.V, tx.R, tx.S)
+		to, value, input, gas, nonce = tx.To, tx.Value, tx.Data, tx.Gas, tx.Nonce
+		txInfo.GasPrice = weiToUnit(tx.GasPrice, 9)
+
+	case data[0] == types.AccessListTxType:
+		var tx rlpAccessListTx
+		if err := rlp.DecodeBytes(data[1:], &tx); err != nil {
+			return nil, fmt.Errorf("invalid EIP-2930 transaction: %s", err)
+		}
+		chainId = tx.ChainID
+		to, value, input, gas, nonce = tx.To, tx.Value, tx.Data, tx.Gas, tx.Nonce
+		txInfo.GasPrice = weiToUnit(tx.GasPrice, 9)
+
+	case data[0] == types.DynamicFeeTxType:
+		var tx rlpDynamicFeeTx
+		if err := rlp.DecodeBytes(data[1:], &tx); err != nil {
+			return nil, fmt.Errorf("invalid EIP-1559 transaction: %s", err)
+		}
+		chainId = tx.ChainID
+		to, value, input, gas, nonce = tx.To, tx.Value, tx.Data, tx.Gas, tx.Nonce
+		txInfo.Eip1559 = true
+		txInfo.MaxFeePerGas = weiToUnit(tx.GasFeeCap, 9)
+		txInfo.MaxPriorityFeePerGas = weiToUnit(tx.GasTipCap, 9)
+
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", data[0])
+	}
+
+	// 没有chain id的交易可以在任意链上广播，不能确认审批的是哪条链上的交易
+	if chainId == nil {
+		return nil, fmt.Errorf("transaction has no chain id, legacy transactions without EIP-155 are not supported")
+	}
+	expected, ok := evmChainIds[chainName]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain id of %s", chainName)
+	}
+	if chainId.Cmp(big.NewInt(expected)) != 0 {
+		return nil, fmt.Errorf("chain id %s does not match %s (%d)", chainId, chainName, expected)
+	}
+	if to == nil {
+		return nil, fmt.Errorf("contract creation is not supported")
+	}
+
+	txInfo.To = to.Hex()
+	txInfo.Value = weiToUnit(value, 18)
+	if len(input) > 0 {
+		txInfo.Data = hexutil.Encode(input)
+	}
+	txInfo.GasLimit = strconv.FormatUint(gas, 10)
+	txInfo.Nonce = strconv.FormatUint(nonce, 10)
+	return txInfo, nil
+}
+
+// legacy交易的chain id，没有签名或EIP-155之前的签名返回nil
+func legacyChainId(v, r, s *big.Int) *big.Int {
+	if v == nil || v.Sign() == 0 {
+		return nil
+	}
+	if (r == nil || r.Sign() == 0) && (s == nil || s.Sign() == 0) { //EIP-155未签名交易
+		return v
+	}
+	if v.Cmp(big.NewInt(27)) == 0 || v.Cmp(big.NewInt(28)) == 0 {
+		return nil
+	}
+	// v = chainId*2 + 35/36
+	chainId := new(big.Int).Sub(v, big.NewInt(35))
+	return chainId.Rsh(chainId, 1)
+}
+
+// wei转换为decimals精度的单位，如gwei为9、ether为18
+func weiToUnit(wei *big.Int, decimals int32) string {
+	if wei == nil {
+		return "0"
+	}
+	return decimal.NewFromBigInt(wei, -decimals).String()
+}
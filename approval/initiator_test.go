@@ -0,0 +1,408 @@
+package approval
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ OpenBlockApi = approvaltest.NewFakeApi()
+
+func TestSendApprovalTxInfo(t *testing.T) {
+	newApi := func() *approvaltest.FakeApi {
+		api := approvaltest.NewFakeApi()
+		api.Addresses["ETH"] = "0xfrom"
+		api.HDWallets["hd1"] = &approvaltest.HDWallet{WalletName: "hd", Addresses: map[string]string{"ETH": "0xhdfrom"}}
+		return api
+	}
+
+	t.Run("审批通过返回txHash", func(t *testing.T) {
+		api := newApi()
+		api.AutoAgree = true
+		client := NewClientWithApi("key", "secret", api)
+
+		txHash, err := SendApprovalTxInfo(client, "hd1", &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, txHash)
+
+		r, _ := api.Record("record-1")
+		assert.Equal(t, "0xhdfrom", r.TxInfo.From)
+		assert.Equal(t, "TRANSACTION", r.Action)
+	})
+
+	t.Run("审批拒绝", func(t *testing.T) {
+		api := newApi()
+		api.OnNewApproval = func(r *approvaltest.Record) {
+			r.Status = approvaltest.StatusReject
+		}
+		client := NewClientWithApi("key", "secret", api)
+
+		_, err := SendApprovalTxInfo(client, "-", &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"})
+		assert.ErrorContains(t, err, "approval rejected")
+	})
+
+	t.Run("消息签名返回签名结果", func(t *testing.T) {
+		api := newApi()
+		api.AutoAgree = true
+		api.OnAgree = func(r *approvaltest.Record) {
+			r.Authorization = &apisdk.Authorization{FinalHash: "0xsignature"}
+		}
+		client := NewClientWithApi("key", "secret", api)
+
+		res, err := SignApprovalMessage(client, "-", ETHEREUM, "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "0xsignature", res)
+	})
+
+	t.Run("只签名返回rawTx", func(t *testing.T) {
+		api := newApi()
+		api.AutoAgree = true
+		api.OnAgree = func(r *approvaltest.Record) {
+			r.CustomData = `{"data": "[\"digest\", [\"rawtx\"], \"\", \"\"]"}`
+		}
+		client := NewClientWithApi("key", "secret", api)
+
+		res, err := SendApprovalTxInfo(client, "-", &apisdk.TXInfo{Chain: BENFEN, Data: "00", BridgeMethod: "bfc_signTransaction"})
+		assert.NoError(t, err)
+		assert.Equal(t, "rawtx", res)
+
+		r, _ := api.Record("record-1")
+		assert.Equal(t, "TRANSACTION_CONTRACT_INTERACTION", r.Action)
+		assert.Equal(t, int32(300), r.ExpiredTimeout)
+	})
+
+	t.Run("只签名结果格式错误", func(t *testing.T) {
+		handle := &ApprovalHandle{RecordId: "record-1", Chain: BENFEN, BridgeMethod: "bfc_signTransaction"}
+		for customData, reason := range map[string]string{
+			`{"data": []}`:  "invalid customData",
+			`{"data": [1]}`: "invalid customData",
+			`{"data": 1}`:   "invalid customData",
+			`{"data": "[\"digest\", [], \"\", \"\"]"}`:    "invalid benfen tx data",
+			`{"data": "[\"digest\", \"x\", \"\", \"\"]"}`: "invalid benfen tx data",
+			`{"data": "[\"digest\", [1], \"\", \"\"]"}`:   "invalid benfen tx data",
+		} {
+			_, err := approvalResult(handle, "", apisdk.ExtraData{CustomData: customData})
+			assert.ErrorContains(t, err, reason, customData)
+		}
+	})
+
+	t.Run("context取消停止等待", func(t *testing.T) {
+		client := NewClientWithApi("key", "secret", newApi())
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := SendApprovalTxInfoContext(ctx, client, "-", &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("发起后等待审批结果", func(t *testing.T) {
+		api := newApi()
+		client := NewClientWithApi("key", "secret", api)
+
+		handle, err := SubmitApprovalTxInfo(client, "hd1", &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"})
+		assert.NoError(t, err)
+		assert.Equal(t, &ApprovalHandle{RecordId: "record-1", Action: "TRANSACTION", Chain: "ETH"}, handle)
+
+		status, err := GetApprovalStatus(client, handle)
+		assert.NoError(t, err)
+		assert.Equal(t, approvaltest.StatusIng, status.Status)
+		assert.False(t, status.Done)
+
+		// 超时后审批仍然存在，可以继续等待
+		_, err = WaitApproval(client, handle, WaitOptions{Interval: 10 * time.Millisecond, Timeout: 30 * time.Millisecond})
+		assert.ErrorIs(t, err, ErrApprovalTimeout)
+
+		assert.NoError(t, api.Agree("record-1"))
+		res, err := WaitApproval(client, &ApprovalHandle{RecordId: handle.RecordId}, WaitOptions{Interval: 10 * time.Millisecond})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, res)
+	})
+
+	t.Run("只保存recordId时使用审批记录中的方法", func(t *testing.T) {
+		api := newApi()
+		api.AutoAgree = true
+		api.OnAgree = func(r *approvaltest.Record) {
+			r.CustomData = `{"data": "[\"digest\", [\"rawtx\"], \"\", \"\"]"}`
+		}
+		client := NewClientWithApi("key", "secret", api)
+		handle, err := SubmitApprovalTxInfo(client, "-", &apisdk.TXInfo{Chain: BENFEN, Data: "00", BridgeMethod: "bfc_signTransaction"})
+		assert.NoError(t, err)
+
+		status, err := GetApprovalStatus(client, &ApprovalHandle{RecordId: handle.RecordId})
+		assert.NoError(t, err)
+		assert.True(t, status.Done)
+		assert.Equal(t, "rawtx", status.Result)
+	})
+
+	t.Run("备注和过期时间", func(t *testing.T) {
+		server := approvaltest.NewServer(newApi())
+		defer server.Close()
+		client := NewClient("key", "secret")
+		client.SetApiUrl(server.URL)
+
+		_, err := SubmitApprovalTxInfo(client, "-", &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"}, ApprovalOptions{Note: "INV-1001", ExpiredSeconds: 600})
+		assert.NoError(t, err)
+		r, _ := server.Api.Record("record-1")
+		assert.Equal(t, "INV-1001", r.Note)
+		assert.Equal(t, int32(600), r.ExpiredTimeout)
+
+		// 合约交互默认300秒过期，小于0时不过期
+		_, err = SubmitApprovalTxInfo(client, "-", &apisdk.TXInfo{Chain: "ETH", To: "0xto", TransactionType: "contract"}, ApprovalOptions{ExpiredSeconds: -1, Action: "TRANSACTION"})
+		assert.NoError(t, err)
+		r, _ = server.Api.Record("record-2")
+		assert.Equal(t, int32(0), r.ExpiredTimeout)
+		assert.Equal(t, "TRANSACTION", r.Action)
+	})
+
+	t.Run("查询间隔退避", func(t *testing.T) {
+		opts := WaitOptions{Interval: time.Second, Multiplier: 2, MaxInterval: 3 * time.Second}.withDefaults()
+		assert.Equal(t, 90*time.Second, opts.Timeout)
+		assert.Equal(t, 2*time.Second, opts.next(time.Second))
+		assert.Equal(t, 3*time.Second, opts.next(2*time.Second))
+		assert.Equal(t, time.Second, WaitOptions{Interval: time.Second}.next(time.Second))
+	})
+}
+
+func TestSubmitApprovalTxInfoWithKey(t *testing.T) {
+	api := approvaltest.NewFakeApi()
+	api.Addresses["ETH"] = "0xfrom"
+	path := filepath.Join(t.TempDir(), "store.json")
+	newClient := func() *Client {
+		store, err := NewStore(path)
+		assert.NoError(t, err)
+		client := NewClientWithApi("key", "secret", api)
+		client.Store = store
+		client.WaitOptions = WaitOptions{Interval: 10 * time.Millisecond, Timeout: time.Second}
+		return client
+	}
+	txInfo := func() *apisdk.TXInfo {
+		return &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"}
+	}
+
+	t.Run("重启后继续等待已发起的审批", func(t *testing.T) {
+		handle, err := SubmitApprovalTxInfoWithKey(newClient(), "pay-1", "-", txInfo())
+		assert.NoError(t, err)
+
+		// 新进程使用同一个key，不重复发起
+		client := newClient()
+		assert.NoError(t, api.Agree(handle.RecordId))
+		res, err := SendApprovalTxInfoWithKey(client, "pay-1", "-", txInfo())
+		assert.NoError(t, err)
+		assert.NotEmpty(t, res)
+		assert.Equal(t, 1, api.Calls("NewApproval"))
+
+		initiation, ok := client.Store.Initiation("pay-1")
+		assert.True(t, ok)
+		assert.Equal(t, handle.RecordId, initiation.Handle.RecordId)
+	})
+
+	t.Run("同一个key不能用于不同的txInfo", func(t *testing.T) {
+		other := txInfo()
+		other.Value = "2"
+		_, err := SubmitApprovalTxInfoWithKey(newClient(), "pay-1", "-", other)
+		assert.ErrorContains(t, err, "idempotency key pay-1 was used with a different txInfo")
+
+		// 备注、过期时间不同时同样拒绝
+		_, err = SubmitApprovalTxInfoWithKey(newClient(), "pay-1", "-", txInfo(), ApprovalOptions{Note: "INV-2"})
+		assert.ErrorContains(t, err, "idempotency key pay-1 was used with a different txInfo or approval options")
+		_, err = SubmitApprovalTxInfoWithKey(newClient(), "pay-1", "-", txInfo(), ApprovalOptions{ExpiredSeconds: 60})
+		assert.ErrorContains(t, err, "idempotency key pay-1 was used with a different txInfo or approval options")
+	})
+
+	t.Run("只能传一个ApprovalOptions", func(t *testing.T) {
+		calls := api.Calls("NewApproval")
+		_, err := SubmitApprovalTxInfoWithKey(newClient(), "pay-5", "-", txInfo(), ApprovalOptions{Note: "a"}, ApprovalOptions{Note: "b"})
+		assert.ErrorContains(t, err, "at most one ApprovalOptions is allowed, got 2")
+		_, err = SubmitApprovalTxInfo(newClient(), "-", txInfo(), ApprovalOptions{}, ApprovalOptions{})
+		assert.ErrorContains(t, err, "at most one ApprovalOptions is allowed, got 2")
+		assert.Equal(t, calls, api.Calls("NewApproval"))
+		_, ok := newClient().Store.Initiation("pay-5")
+		assert.False(t, ok)
+	})
+
+	t.Run("发起中断时不重复发起", func(t *testing.T) {
+		client := newClient()
+		assert.NoError(t, client.Store.PutInitiation(Initiation{Key: "pay-2", TxInfoHash: txInfoHash("-", txInfo(), ApprovalOptions{}), CreatedAt: time.Now()}))
+		_, err := SubmitApprovalTxInfoWithKey(client, "pay-2", "-", txInfo())
+		assert.ErrorIs(t, err, ErrInitiationInterrupted)
+
+		// 查询后手动指定已创建的审批
+		id := api.AddApproval("", "TRANSACTION", *txInfo())
+		assert.NoError(t, client.Store.PutInitiation(Initiation{Key: "pay-2", TxInfoHash: txInfoHash("-", txInfo(), ApprovalOptions{}), Handle: &ApprovalHandle{RecordId: id}}))
+		handle, err := SubmitApprovalTxInfoWithKey(client, "pay-2", "-", txInfo())
+		assert.NoError(t, err)
+		assert.Equal(t, id, handle.RecordId)
+	})
+
+	t.Run("发起失败时删除记录", func(t *testing.T) {
+		client := newClient()
+		_, err := SubmitApprovalTxInfoWithKey(client, "pay-3", "-", &apisdk.TXInfo{To: "0xto"})
+		assert.ErrorContains(t, err, "TXInfo.Chain is required")
+		_, ok := client.Store.Initiation("pay-3")
+		assert.False(t, ok)
+
+		_, err = SubmitApprovalTxInfoWithKey(client, "pay-3", "-", txInfo())
+		assert.NoError(t, err)
+	})
+
+	t.Run("无法确定是否已创建时保留记录", func(t *testing.T) {
+		server := approvaltest.NewServer(nil)
+		defer server.Close()
+		assert.NoError(t, server.SetScript(approvaltest.Script{Addresses: map[string]string{"ETH": "0xfrom"}}))
+		client := newClient()
+		client.SetApiUrl(server.URL)
+		client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+		for i, e := range []struct {
+			err     approvaltest.ScriptError
+			removed bool
+		}{
+			{approvaltest.ScriptError{StatusCode: 400, ErrMsg: "bad request"}, true},
+			{approvaltest.ScriptError{StatusCode: 401, ErrMsg: "unauthorized"}, true},
+			{approvaltest.ScriptError{StatusCode: 502, ErrMsg: "bad gateway"}, false},
+			{approvaltest.ScriptError{StatusCode: 408, ErrMsg: "request timeout"}, false},
+			{approvaltest.ScriptError{ErrCode: "50000", ErrMsg: "internal error"}, false},
+		} {
+			e.err.Path, e.err.Times = "/openapi/company_wallet/approval/new/", 1
+			assert.NoError(t, server.SetScript(approvaltest.Script{Errors: []approvaltest.ScriptError{e.err}}))
+			key := fmt.Sprintf("pay-err-%d", i)
+			_, err := SubmitApprovalTxInfoWithKey(client, key, "-", txInfo())
+			assert.ErrorContains(t, err, e.err.ErrMsg)
+			_, ok := client.Store.Initiation(key)
+			assert.Equal(t, e.removed, !ok, e.err.ErrMsg)
+		}
+	})
+
+	t.Run("存储没有文件路径", func(t *testing.T) {
+		client := NewClientWithApi("key", "secret", api)
+		calls := api.Calls("NewApproval")
+		_, err := SubmitApprovalTxInfoWithKey(client, "pay-4", "-", txInfo())
+		assert.ErrorContains(t, err, "requires a store with a file path")
+		assert.Equal(t, calls, api.Calls("NewApproval"))
+	})
+}
+
+func TestBuildEvmRawTx(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.HexToAddress("0xc8F31688cc615aD31d2570db89B0Be10be2e44Fb")
+	gwei := big.NewInt(params.GWei)
+	value := big.NewInt(params.Ether)
+	encode := func(tx *types.Transaction) string {
+		data, err := tx.MarshalBinary()
+		assert.NoError(t, err)
+		return hexutil.Encode(data)
+	}
+
+	t.Run("签名的EIP-1559交易", func(t *testing.T) {
+		tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(137)), &types.DynamicFeeTx{
+			ChainID:   big.NewInt(137),
+			Nonce:     5,
+			GasTipCap: new(big.Int).Mul(big.NewInt(25), gwei),
+			GasFeeCap: new(big.Int).Mul(big.NewInt(50), gwei),
+			Gas:       21000,
+			To:        &to,
+			Value:     value,
+			Data:      []byte{0xa9, 0x05, 0x9c, 0xbb},
+		})
+		assert.NoError(t, err)
+
+		txInfo, err := BuildTxInfo(POLYGON, encode(tx), true)
+		assert.NoError(t, err)
+		assert.Equal(t, to.Hex(), txInfo.To)
+		assert.Equal(t, "1", txInfo.Value)
+		assert.Equal(t, "0xa9059cbb", txInfo.Data)
+		assert.Equal(t, "21000", txInfo.GasLimit)
+		assert.Equal(t, "5", txInfo.Nonce)
+		assert.True(t, txInfo.Eip1559)
+		assert.Equal(t, "50", txInfo.MaxFeePerGas)
+		assert.Equal(t, "25", txInfo.MaxPriorityFeePerGas)
+		assert.Equal(t, POLYGON, txInfo.Chain)
+		assert.Equal(t, "eth_signTransaction", txInfo.BridgeMethod)
+	})
+
+	t.Run("未签名的EIP-1559交易", func(t *testing.T) {
+		// ethers/foundry的未签名交易不包含v/r/s
+		data, err := rlp.EncodeToBytes([]interface{}{
+			big.NewInt(1), uint64(0), gwei, new(big.Int).Mul(big.NewInt(3), gwei), uint64(21000), to, new(big.Int).Div(value, big.NewInt(1000)), []byte{}, types.AccessList{},
+		})
+		assert.NoError(t, err)
+		txInfo, err := BuildTxInfo(ETHEREUM, hexutil.Encode(append([]byte{types.DynamicFeeTxType}, data...)), false)
+		assert.NoError(t, err)
+		assert.Equal(t, "0.001", txInfo.Value)
+		assert.Equal(t, "3", txInfo.MaxFeePerGas)
+		assert.Empty(t, txInfo.Data)
+
+		_, err = BuildTxInfo(BSC, hexutil.Encode(append([]byte{types.DynamicFeeTxType}, data...)), false)
+		assert.ErrorContains(t, err, "chain id 1 does not match BSC (56)")
+	})
+
+	t.Run("legacy和EIP-2930交易", func(t *testing.T) {
+		legacy, err := types.SignNewTx(key, types.NewEIP155Signer(big.NewInt(56)), &types.LegacyTx{
+			Nonce: 1, GasPrice: new(big.Int).Div(gwei, big.NewInt(10)), Gas: 21000, To: &to, Value: value,
+		})
+		assert.NoError(t, err)
+		txInfo, err := BuildTxInfo(BSC, encode(legacy), false)
+		assert.NoError(t, err)
+		assert.Equal(t, "0.1", txInfo.GasPrice)
+		assert.False(t, txInfo.Eip1559)
+		_, err = BuildTxInfo(ETHEREUM, encode(legacy), false)
+		assert.ErrorContains(t, err, "chain id 56 does not match ETH (1)")
+
+		// EIP-155未签名交易: v为chain id，r、s为0
+		unsigned, err := rlp.EncodeToBytes([]interface{}{uint64(2), gwei, uint64(21000), to, value, []byte{}, big.NewInt(42161), uint(0), uint(0)})
+		assert.NoError(t, err)
+		txInfo, err = BuildTxInfo(ARBITRUM, hexutil.Encode(unsigned), false)
+		assert.NoError(t, err)
+		assert.Equal(t, "2", txInfo.Nonce)
+		_, err = BuildTxInfo(OPTIMISM, hexutil.Encode(unsigned), false)
+		assert.ErrorContains(t, err, "does not match")
+
+		// 没有chain id的legacy交易不能确认所在的链
+		homestead, err := types.SignNewTx(key, types.HomesteadSigner{}, &types.LegacyTx{Nonce: 1, GasPrice: gwei, Gas: 21000, To: &to, Value: value})
+		assert.NoError(t, err)
+		_, err = BuildTxInfo(ETHEREUM, encode(homestead), false)
+		assert.ErrorContains(t, err, "transaction has no chain id")
+		noChainId, err := rlp.EncodeToBytes([]interface{}{uint64(2), gwei, uint64(21000), to, value, []byte{}})
+		assert.NoError(t, err)
+		_, err = BuildTxInfo(ETHEREUM, hexutil.Encode(noChainId), false)
+		assert.ErrorContains(t, err, "transaction has no chain id")
+
+		accessList, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(10)), &types.AccessListTx{
+			ChainID: big.NewInt(10), Nonce: 3, GasPrice: gwei, Gas: 50000, To: &to, Value: big.NewInt(0),
+			AccessList: types.AccessList{{Address: to, StorageKeys: []common.Hash{{}}}},
+		})
+		assert.NoError(t, err)
+		txInfo, err = BuildTxInfo(OPTIMISM, encode(accessList), false)
+		assert.NoError(t, err)
+		assert.Equal(t, "0", txInfo.Value)
+		assert.Equal(t, "1", txInfo.GasPrice)
+		assert.Equal(t, "50000", txInfo.GasLimit)
+	})
+
+	t.Run("无效交易", func(t *testing.T) {
+		create, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{ChainID: big.NewInt(1), Gas: 21000, Data: []byte{0x60}})
+		assert.NoError(t, err)
+		_, err = BuildTxInfo(ETHEREUM, encode(create), false)
+		assert.ErrorContains(t, err, "contract creation is not supported")
+
+		_, err = BuildTxInfo(ETHEREUM, "0x03c0", false)
+		assert.ErrorContains(t, err, "unsupported transaction type 3")
+		_, err = BuildTxInfo(ETHEREUM, "0xzz", false)
+		assert.ErrorContains(t, err, "invalid raw transaction hex")
+		_, err = BuildTxInfo(ETHEREUM, "0x02c101", false)
+		assert.ErrorContains(t, err, "invalid EIP-1559 transaction")
+	})
+}
@@ -0,0 +1,34 @@
+// openblock企业钱包接口的签名，approval包的接口实现和approvaltest模拟服务共用
+package apisign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+  - 与apisdk.CompanyWalletClient相同的签名算法: 参数按key排序拼接后加上api_key，使用api secret计算HMAC-SHA256
+    params中的sign和api_key不参与拼接，返回大写hex
+*/
+func Sign(params map[string]any, apiKey, apiSecret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "sign" && k != "api_key" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var strs string
+	for _, k := range keys {
+		strs += fmt.Sprintf("%s=%v&", k, params[k])
+	}
+	strs += fmt.Sprintf("api_key=%s", apiKey)
+
+	h := hmac.New(sha256.New, []byte(apiSecret))
+	h.Write([]byte(strs))
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
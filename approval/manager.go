@@ -2,11 +2,13 @@ package approval
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"log"
 )
@@ -17,54 +19,175 @@ type SignResult struct {
 	Data    any    `json:"data"`
 }
 
-func AutoSign(client *Client, approvalParams *[]ApprovalParams, dockerPort string) error {
-	result, err := AutoApprove(client, approvalParams)
-	if err != nil {
-		return err
+// 单条记录的签名结果
+type SignResults struct {
+	ApprovalId string
+	Method     string // docker签名接口: sign_message/sign_transaction/send_transaction
+	Signed     bool   // docker已确认签名
+	Retry      bool   // 来自待签名队列，之前的周期签名失败
+	Attempts   int    // 累计签名尝试次数
+	DryRun     bool
+	Data       any // docker返回的签名结果
+	Err        error
+}
+
+/*
+  - 自动审批并调用docker签名
+    已审批但签名失败的记录保存在client.Store的待签名队列中，后续每次调用时重试，直到docker确认签名
+    返回值: 每条记录的签名结果，所有审批和签名错误合并后返回
+*/
+func AutoSign(client *Client, approvalParams *[]ApprovalParams, dockerPort string) ([]SignResults, error) {
+	return AutoSignContext(context.Background(), client, approvalParams, dockerPort)
+}
+
+func AutoSignContext(ctx context.Context, client *Client, approvalParams *[]ApprovalParams, dockerPort string) ([]SignResults, error) {
+	return AutoSignPolicyContext(ctx, client, CompilePolicy(*approvalParams), dockerPort)
+}
+
+// 同AutoSignContext，使用编译后的规则
+func AutoSignPolicyContext(ctx context.Context, client *Client, policy *Policy, dockerPort string) ([]SignResults, error) {
+	var errs []error
+	var results []SignResults
+
+	// 先重试之前周期签名失败的记录
+	retried := map[string]bool{}
+	for _, pending := range client.Store.PendingSigns() {
+		retried[pending.ApprovalId] = true
+		if err := ctx.Err(); err != nil {
+			return results, errors.Join(append(errs, err)...)
+		}
+		res := signPending(ctx, client, dockerPort, pending, true)
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+		}
+		results = append(results, res)
 	}
-	for _, res := range result {
-		if !res.Approved {
+
+	// 部分审批失败时继续签名已通过的审批
+	approveResults, approveErr := AutoApprovePolicyContext(ctx, client, policy)
+	if approveErr != nil {
+		errs = append(errs, approveErr)
+	}
+	var pendings []PendingSign
+	for _, res := range approveResults {
+		if !res.Approved || retried[res.ApprovalId] {
 			continue
 		}
-		url := "http://localhost:%s/openapi/sign/%s?key=%s"
-		if res.Action == "TRANSACTION_SIGNATURE" {
-			url = fmt.Sprintf(url, dockerPort, "sign_message", client.ApiKey)
-		} else if res.OnlySign {
-			url = fmt.Sprintf(url, dockerPort, "sign_transaction", client.ApiKey)
-		} else {
-			url = fmt.Sprintf(url, dockerPort, "send_transaction", client.ApiKey)
+		method := signMethod(res.Action, res.OnlySign)
+		if res.DryRun {
+			log.Printf("dry-run, would call docker request: %s, %s\n", signUrl(dockerPort, method, client.ApiKey), signBody(res.ApprovalId))
+			results = append(results, SignResults{ApprovalId: res.ApprovalId, Method: method, DryRun: true})
+			continue
 		}
-
-		data := fmt.Sprintf(`{"company_wallet_approve_record_id": "%s"}`, res.ApprovalId)
-		log.Printf("call docker request: %s, %s\n", url, data)
-
-		resp, err := http.Post(url, "application/json", bytes.NewBufferString(data))
-		if err != nil {
-			return fmt.Errorf("failed to send sign request: %w", err)
+		// 审批已提交，先加入待签名队列，进程中断后也能继续签名
+		now := time.Now()
+		pending := PendingSign{ApprovalId: res.ApprovalId, Method: method, CreatedAt: now, UpdatedAt: now}
+		if err := client.Store.PutPendingSign(pending); err != nil {
+			log.Printf("save pending sign %s failed: %v", res.ApprovalId, err)
 		}
-		defer resp.Body.Close()
+		pendings = append(pendings, pending)
+	}
 
-		// Read response
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read response body: %w", err)
+	for _, pending := range pendings {
+		if err := ctx.Err(); err != nil {
+			return results, errors.Join(append(errs, err)...)
 		}
-
-		// Check HTTP status code
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("sign request failed with status code: %d, body: %s", resp.StatusCode, string(body))
+		res := signPending(ctx, client, dockerPort, pending, false)
+		if res.Err != nil {
+			errs = append(errs, res.Err)
 		}
+		results = append(results, res)
+	}
+	return results, errors.Join(errs...)
+}
+
+// 调用docker签名，成功后移出待签名队列，失败时更新尝试次数
+func signPending(ctx context.Context, client *Client, dockerPort string, pending PendingSign, retry bool) SignResults {
+	res := SignResults{ApprovalId: pending.ApprovalId, Method: pending.Method, Retry: retry, Attempts: pending.Attempts + 1}
+	if client.DryRun {
+		log.Printf("dry-run, would retry docker request: %s, %s\n", signUrl(dockerPort, pending.Method, client.ApiKey), signBody(pending.ApprovalId))
+		res.DryRun = true
+		return res
+	}
 
-		var signRes SignResult
-		err = json.Unmarshal(body, &signRes)
+	data, err := callSigner(ctx, signUrl(dockerPort, pending.Method, client.ApiKey), signBody(pending.ApprovalId))
+	if err != nil {
+		res.Err = fmt.Errorf("sign %s: %w", pending.ApprovalId, err)
+		pending.Attempts = res.Attempts
+		pending.LastError = err.Error()
+		pending.UpdatedAt = time.Now()
+		if client.MaxSignAttempts > 0 && pending.Attempts >= client.MaxSignAttempts {
+			log.Printf("Approval ID %s sign failed %d times, removed from pending queue", pending.ApprovalId, pending.Attempts)
+			err = client.Store.RemovePendingSign(pending.ApprovalId)
+		} else {
+			err = client.Store.PutPendingSign(pending)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal response: %w, body: %s", err, string(body))
+			log.Printf("save pending sign %s failed: %v", pending.ApprovalId, err)
 		}
+		return res
+	}
 
-		if signRes.Code != 0 {
-			return errors.New(signRes.Message)
-		}
-		log.Printf("Approval ID %s signed successfully, result: %s\n", res.ApprovalId, signRes.Data)
+	log.Printf("Approval ID %s signed successfully, result: %s\n", pending.ApprovalId, data)
+	res.Signed = true
+	res.Data = data
+	if err := client.Store.RemovePendingSign(pending.ApprovalId); err != nil {
+		log.Printf("remove pending sign %s failed: %v", pending.ApprovalId, err)
+	}
+	return res
+}
+
+func signMethod(action string, onlySign bool) string {
+	if action == "TRANSACTION_SIGNATURE" {
+		return "sign_message"
+	} else if onlySign {
+		return "sign_transaction"
+	}
+	return "send_transaction"
+}
+
+func signUrl(dockerPort, method, apiKey string) string {
+	return fmt.Sprintf("http://localhost:%s/openapi/sign/%s?key=%s", dockerPort, method, apiKey)
+}
+
+func signBody(approvalId string) string {
+	return fmt.Sprintf(`{"company_wallet_approve_record_id": "%s"}`, approvalId)
+}
+
+// 调用docker签名接口，返回签名结果
+func callSigner(ctx context.Context, url, data string) (any, error) {
+	log.Printf("call docker request: %s, %s\n", url, data)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sign request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send sign request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Check HTTP status code
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sign request failed with status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var signRes SignResult
+	err = json.Unmarshal(body, &signRes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w, body: %s", err, string(body))
+	}
+
+	if signRes.Code != 0 {
+		return nil, errors.New(signRes.Message)
 	}
-	return nil
+	return signRes.Data, nil
 }
@@ -0,0 +1,163 @@
+package approval
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoSignWithEmulator(t *testing.T) {
+	params := []ApprovalParams{
+		{MatchParams: []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}}},
+	}
+	newServer := func(t *testing.T) (*approvaltest.Server, *Client, string) {
+		server := approvaltest.NewServer(nil)
+		t.Cleanup(server.Close)
+		server.ApiKey, server.ApiSecret = "key", "secret"
+		client := NewClient("key", "secret")
+		client.SetApiUrl(server.URL)
+		u, _ := url.Parse(server.URL)
+		return server, client, u.Port()
+	}
+
+	t.Run("审批并调用docker签名", func(t *testing.T) {
+		server, client, port := newServer(t)
+		send := server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		sign := server.Api.AddApproval("", "TRANSACTION_SIGNATURE", apisdk.TXInfo{Chain: "ETH", Method: "personal_sign"})
+
+		results, err := AutoSign(client, &params, port)
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		assert.True(t, results[0].Signed)
+		assert.Equal(t, "send_transaction", results[0].Method)
+		requests := server.SignRequests()
+		assert.Equal(t, []approvaltest.SignRequest{
+			{Method: "send_transaction", RecordId: send, Key: "key"},
+			{Method: "sign_message", RecordId: sign, Key: "key"},
+		}, requests)
+	})
+
+	t.Run("签名服务返回错误", func(t *testing.T) {
+		server, client, port := newServer(t)
+		server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Errors: []approvaltest.ScriptError{{Path: "/openapi/sign/send_transaction", ErrMsg: "mpc offline"}},
+		}))
+
+		results, err := AutoSign(client, &params, port)
+		assert.ErrorContains(t, err, "mpc offline")
+		assert.Len(t, results, 1)
+		assert.False(t, results[0].Signed)
+	})
+
+	t.Run("签名失败不影响其他记录并在下次重试", func(t *testing.T) {
+		server, client, port := newServer(t)
+		first := server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		second := server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "2"})
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Errors: []approvaltest.ScriptError{{Path: "/openapi/sign/send_transaction", ErrMsg: "mpc offline", Times: 1}},
+		}))
+
+		results, err := AutoSign(client, &params, port)
+		assert.ErrorContains(t, err, "mpc offline")
+		assert.Len(t, results, 2)
+		assert.Equal(t, first, results[0].ApprovalId)
+		assert.Error(t, results[0].Err)
+		assert.Equal(t, second, results[1].ApprovalId)
+		assert.True(t, results[1].Signed)
+
+		pendings := client.Store.PendingSigns()
+		assert.Len(t, pendings, 1)
+		assert.Equal(t, first, pendings[0].ApprovalId)
+		assert.Equal(t, 1, pendings[0].Attempts)
+		assert.Equal(t, "mpc offline", pendings[0].LastError)
+
+		// 记录已不是ING，通过待签名队列重试
+		results, err = AutoSign(client, &params, port)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, first, results[0].ApprovalId)
+		assert.True(t, results[0].Retry)
+		assert.True(t, results[0].Signed)
+		assert.Equal(t, 2, results[0].Attempts)
+		assert.Empty(t, client.Store.PendingSigns())
+	})
+
+	t.Run("超过最大尝试次数移出队列", func(t *testing.T) {
+		server, client, port := newServer(t)
+		server.Api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", Value: "1"})
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Errors: []approvaltest.ScriptError{{Path: "/openapi/sign/send_transaction", ErrMsg: "mpc offline"}},
+		}))
+		client.MaxSignAttempts = 2
+
+		_, err := AutoSign(client, &params, port)
+		assert.Error(t, err)
+		assert.Len(t, client.Store.PendingSigns(), 1)
+		_, err = AutoSign(client, &params, port)
+		assert.Error(t, err)
+		assert.Empty(t, client.Store.PendingSigns())
+	})
+
+	t.Run("待签名队列持久化", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "store.json")
+		store, err := NewStore(path)
+		assert.NoError(t, err)
+		assert.NoError(t, store.PutPendingSign(PendingSign{ApprovalId: "a", Method: "send_transaction"}))
+		assert.NoError(t, store.PutPendingSign(PendingSign{ApprovalId: "b", Method: "sign_message"}))
+		assert.NoError(t, store.PutPendingSign(PendingSign{ApprovalId: "a", Method: "send_transaction", Attempts: 1}))
+		assert.NoError(t, store.RemovePendingSign("b"))
+
+		store, err = NewStore(path)
+		assert.NoError(t, err)
+		assert.Equal(t, []PendingSign{{ApprovalId: "a", Method: "send_transaction", Attempts: 1}}, store.PendingSigns())
+	})
+
+	t.Run("接口返回错误码", func(t *testing.T) {
+		server, client, port := newServer(t)
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Errors: []approvaltest.ScriptError{{Path: "/openapi/company_wallet/approvals/", ErrCode: "40001", ErrMsg: "invalid status", Times: 1}},
+		}))
+
+		_, err := AutoSign(client, &params, port)
+		assert.ErrorContains(t, err, "invalid status")
+		_, err = AutoSign(client, &params, port)
+		assert.NoError(t, err)
+	})
+
+	t.Run("签名错误", func(t *testing.T) {
+		server, _, _ := newServer(t)
+		client := NewClient("key", "wrong")
+		client.SetApiUrl(server.URL)
+
+		_, err := client.GetApprovals("ING")
+		assert.ErrorContains(t, err, "invalid sign")
+	})
+
+	t.Run("POST数值参数签名", func(t *testing.T) {
+		server, client, _ := newServer(t)
+		assert.NoError(t, server.SetScript(approvaltest.Script{Addresses: map[string]string{"ETH": "0xfrom"}}))
+
+		// 大数值在json中为1000000，服务端需按原文参与签名
+		_, err := SubmitApprovalTxInfo(client, "-", &apisdk.TXInfo{Chain: "ETH", To: "0xto", Value: "1"}, ApprovalOptions{ExpiredSeconds: 1000000})
+		assert.NoError(t, err)
+	})
+
+	t.Run("发起审批延迟通过", func(t *testing.T) {
+		server, client, _ := newServer(t)
+		assert.NoError(t, server.SetScript(approvaltest.Script{
+			Addresses: map[string]string{"ETH": "0xfrom"},
+			Decision:  "agree",
+			Delay:     "10ms",
+			FinalHash: "0xsignature",
+		}))
+
+		res, err := SignApprovalMessage(client, "-", ETHEREUM, "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "0xsignature", res)
+	})
+}
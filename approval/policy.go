@@ -0,0 +1,456 @@
+package approval
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+/*
+  - 编译后的审批规则，可在多次AutoApprove之间复用
+    path在编译时拆分，正则在编译时编译，不带单位的数值在编译时解析
+    带单位的数值依赖交易的链和token，在检查时转换
+*/
+type Policy struct {
+	Params    []ApprovalParams // 编译前的规则，编译时复制，只读
+	Version   string           // 规则和资源内容的hash，记录在决策日志中
+	rules     []compiledRules
+	resources *PolicyResources // 编译时复制的资源，编译后不再修改
+}
+
+type compiledRules struct {
+	action         string          // 编译时复制的Action，决策时不再读取Params
+	velocityLimits []VelocityLimit // 编译时复制的VelocityLimits
+	match          []*compiledParam
+	verify         []*compiledParam
+}
+
+// 编译审批规则，无效的规则不会报错，检查时返回失败原因，需要时先调用Validate
+func CompilePolicy(params []ApprovalParams) *Policy {
+	return CompilePolicyWithResources(params, nil)
+}
+
+// 使用指定的合约ABI、token等资源编译审批规则，params和resources会被复制，编译后修改不影响规则，resources为nil时只使用内置资源
+func CompilePolicyWithResources(params []ApprovalParams, resources *PolicyResources) *Policy {
+	if resources == nil {
+		resources = NewPolicyResources()
+	}
+	resources = resources.clone()
+	params = cloneParams(params)
+	p := &Policy{
+		Params:    params,
+		Version:   policyVersion(params, resources),
+		rules:     make([]compiledRules, len(params)),
+		resources: resources,
+	}
+	for i, param := range params {
+		p.rules[i].action = param.Action
+		p.rules[i].velocityLimits = cloneParams([]ApprovalParams{param})[0].VelocityLimits
+		for _, m := range param.MatchParams {
+			p.rules[i].match = append(p.rules[i].match, compileParam(m, resources))
+		}
+		for _, v := range param.VerifyParams {
+			p.rules[i].verify = append(p.rules[i].verify, compileParam(v, resources))
+		}
+	}
+	return p
+}
+
+// 通过json复制规则，包括嵌套的All/Any/Not
+func cloneParams(params []ApprovalParams) []ApprovalParams {
+	if params == nil {
+		return nil
+	}
+	data, _ := json.Marshal(params)
+	var cloned []ApprovalParams
+	json.Unmarshal(data, &cloned)
+	return cloned
+}
+
+// 规则json和资源内容的sha256前12位，没有注册资源时只计算规则
+func policyVersion(params []ApprovalParams, resources *PolicyResources) string {
+	data, _ := json.Marshal(params)
+	if digest := resources.digest(); digest != "" {
+		data = append(data, digest...)
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+/*
+  - 按规则评估txInfo，txInfo为审批记录中的txinfo，返回决策过程
+    不检查VelocityLimits，用于测试和回放规则
+*/
+func (p *Policy) Evaluate(txInfo interface{}) *DecisionTrace {
+	return p.EvaluateNote(txInfo, "")
+}
+
+// 同Evaluate，note为发起审批时的备注，可以通过note路径匹配
+func (p *Policy) EvaluateNote(txInfo interface{}, note string) *DecisionTrace {
+	return p.evaluate("", approvalTxInfoMap(p.resources, txInfo, note))
+}
+
+// 匹配并检查规则，txInfo需要已经添加decoded
+func (p *Policy) evaluate(approvalId string, txInfo map[string]interface{}) *DecisionTrace {
+	trace := &DecisionTrace{ApprovalId: approvalId, PolicyVersion: p.Version}
+	trace.MatchedIndex = p.match(txInfo, trace)
+	if trace.MatchedIndex < 0 {
+		trace.Decision = DecisionSkip
+		trace.Reason = "no matched approval params"
+		return trace
+	}
+	trace.Decision = p.decide(trace.MatchedIndex, txInfo, trace)
+	trace.Escalated = trace.Decision == DecisionSkip
+	return trace
+}
+
+// 按顺序匹配MatchParams，返回匹配的规则序号，未匹配返回-1
+func (p *Policy) match(txInfo map[string]interface{}, trace *DecisionTrace) int {
+	for i, rules := range p.rules {
+		match := MatchTrace{Index: i, Matched: true}
+		for _, param := range rules.match {
+			paramTrace := param.trace(txInfo)
+			match.Params = append(match.Params, paramTrace)
+			if !paramTrace.Passed {
+				match.Matched = false
+				break
+			}
+		}
+		trace.Match = append(trace.Match, match)
+		if match.Matched {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+  - 按第i个规则的Action和VerifyParams决策，返回approve/reject/skip，skip为转人工审批
+    多个VerifyParams失败时，OnFail为reject的优先，原因记录第一个决定结果的失败条件
+*/
+func (p *Policy) decide(i int, txInfo map[string]interface{}, trace *DecisionTrace) string {
+	switch action := p.rules[i].action; action {
+	case DecisionReject, DecisionSkip:
+		trace.Reason = "approval params action is " + action
+		return action
+	}
+
+	decision := DecisionApprove
+	for _, param := range p.rules[i].verify {
+		paramTrace := param.trace(txInfo)
+		trace.Verify = append(trace.Verify, paramTrace)
+		if paramTrace.Passed {
+			continue
+		}
+		onFail := DecisionReject
+		if param.param.OnFail == DecisionSkip {
+			onFail = DecisionSkip
+		}
+		if decision == DecisionApprove || (decision == DecisionSkip && onFail == DecisionReject) {
+			trace.Reason = fmt.Sprintf("verify param %s failed: %s", paramTrace.Path, paramTrace.Reason)
+			decision = onFail
+		}
+	}
+	return decision
+}
+
+type compiledParam struct {
+	param     VerifyParams
+	keys      []pathKey
+	resources *PolicyResources // 带单位的数值转换时使用的token
+
+	regex    *regexp.Regexp
+	regexErr error
+
+	hasUnit    bool        // 规则值带单位，检查时转换
+	decimal    decimalRule // 不带单位时预先解析
+	failReason string      // 数值比较失败原因
+
+	list    []string // 编译时从资源中解析的地址列表
+	listErr error
+	sets    addressSetCache
+
+	all []*compiledParam
+	any []*compiledParam
+	not *compiledParam
+}
+
+func compileParam(param VerifyParams, resources *PolicyResources) *compiledParam {
+	c := &compiledParam{param: param, keys: parsePath(param.Path), resources: resources}
+	if param.Rule == "regex" {
+		c.regex, c.regexErr = regexp.Compile(param.Value)
+	}
+	if param.Rule == "inList" || param.Rule == "notInList" {
+		c.list, c.listErr = resources.lookupAddressLists(param.Value)
+	}
+
+	for _, part := range ruleValueParts(param.Value, param.Rule) {
+		if quantityRe.MatchString(part) {
+			c.hasUnit = true
+		}
+	}
+	if !c.hasUnit {
+		c.decimal = parseDecimalRule(param.Value, param.Rule)
+		c.failReason = decimalFailReason(param.Value, param.Rule)
+	}
+
+	for _, p := range param.All {
+		c.all = append(c.all, compileParam(p, resources))
+	}
+	for _, p := range param.Any {
+		c.any = append(c.any, compileParam(p, resources))
+	}
+	if param.Not != nil {
+		c.not = compileParam(*param.Not, resources)
+	}
+	return c
+}
+
+// 检查参数并返回检查过程，同一个VerifyParams中设置的Path/All/Any/Not需要同时满足
+func (c *compiledParam) trace(txInfo map[string]interface{}) ParamTrace {
+	param := c.param
+	if !param.isGroup() {
+		return c.traceLeaf(txInfo)
+	}
+
+	trace := ParamTrace{Path: param.Path, Value: param.Value, Rule: param.Rule, Passed: true}
+	if param.Path != "" {
+		leaf := c.traceLeaf(txInfo)
+		trace.Actual = leaf.Actual
+		trace.Passed = leaf.Passed
+		trace.Reason = leaf.Reason
+	}
+
+	if len(c.all) > 0 {
+		group := ParamTrace{Group: "all", Passed: true}
+		for _, p := range c.all {
+			child := p.trace(txInfo)
+			group.Passed = group.Passed && child.Passed
+			group.Children = append(group.Children, child)
+		}
+		if !group.Passed {
+			group.Reason = "not all conditions passed"
+		}
+		trace.Children = append(trace.Children, group)
+	}
+	if len(c.any) > 0 {
+		group := ParamTrace{Group: "any"}
+		for _, p := range c.any {
+			child := p.trace(txInfo)
+			group.Passed = group.Passed || child.Passed
+			group.Children = append(group.Children, child)
+		}
+		if !group.Passed {
+			group.Reason = "no condition passed"
+		}
+		trace.Children = append(trace.Children, group)
+	}
+	if c.not != nil {
+		child := c.not.trace(txInfo)
+		group := ParamTrace{Group: "not", Passed: !child.Passed, Children: []ParamTrace{child}}
+		// 取反的条件中path不存在时无法判断，按失败处理，避免path写错时放行所有交易
+		if path, missing := c.not.missingPath(txInfo); missing {
+			group.Passed = false
+			group.Reason = fmt.Sprintf("path %s not found", path)
+		} else if !group.Passed {
+			group.Reason = "condition passed"
+		}
+		trace.Children = append(trace.Children, group)
+	}
+
+	for _, group := range trace.Children {
+		if !group.Passed {
+			trace.Passed = false
+			if trace.Reason == "" {
+				trace.Reason = group.Group + " group failed"
+			}
+		}
+	}
+	return trace
+}
+
+// 条件及其子条件中第一个不存在的path
+func (c *compiledParam) missingPath(txInfo map[string]interface{}) (string, bool) {
+	if c.param.Path != "" && getValueByKeys(txInfo, c.keys) == nil {
+		return c.param.Path, true
+	}
+	children := append(append([]*compiledParam(nil), c.all...), c.any...)
+	if c.not != nil {
+		children = append(children, c.not)
+	}
+	for _, child := range children {
+		if path, missing := child.missingPath(txInfo); missing {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// 检查单个Path/Value/Rule条件
+func (c *compiledParam) traceLeaf(txInfo map[string]interface{}) ParamTrace {
+	param := c.param
+	trace := ParamTrace{Path: param.Path, Value: param.Value, Rule: param.Rule}
+	actualValue := getValueByKeys(txInfo, c.keys)
+	if actualValue == nil {
+		trace.Reason = "path not found"
+		return trace
+	}
+	trace.Actual = actualValue
+
+	if param.Rule == "inList" || param.Rule == "notInList" {
+		chain, _ := txInfo["chain"].(string)
+		if c.listErr != nil {
+			trace.Reason = c.listErr.Error()
+			return trace
+		}
+		set := c.sets.get(chain, c.list)
+		trace.Passed, trace.Reason = checkAddressSet(chain, set, actualValue, param.Value, param.Rule)
+		return trace
+	}
+
+	switch actual := actualValue.(type) {
+	case string:
+		actualDecimal, err := decimal.NewFromString(actual)
+		if err != nil {
+			if c.regex != nil {
+				trace.Passed = c.regex.MatchString(actual)
+			} else if c.regexErr == nil {
+				trace.Passed = checkByRule(actual, param.Value, param.Rule)
+			}
+			if !trace.Passed {
+				trace.Reason = stringFailReason(actual, c.regexErr, param.Rule)
+			}
+		} else if c.hasUnit {
+			expected, err := convertUnitValue(c.resources, txInfo, param)
+			if err != nil {
+				trace.Reason = err.Error()
+				return trace
+			}
+			trace.Passed = checkDecimalRule(actualDecimal, expected, param.Rule)
+			if !trace.Passed {
+				trace.Reason = decimalFailReason(expected, param.Rule)
+			}
+		} else {
+			trace.Passed = c.decimal.check(actualDecimal)
+			if !trace.Passed {
+				trace.Reason = c.failReason
+			}
+		}
+
+	case []interface{}:
+		trace.Passed = checkListRule(actual, param.Value, param.Rule)
+		if !trace.Passed {
+			trace.Reason = listFailReason(param.Value, param.Rule)
+		}
+
+	default:
+		trace.Reason = fmt.Sprintf("unsupported value type %T", actual)
+	}
+	return trace
+}
+
+// 解析后的数值比较规则
+type decimalRule struct {
+	rule     string
+	valid    bool
+	expected decimal.Decimal
+	min, max decimal.Decimal
+}
+
+// 解析数值规则，expectedValue不是数值时比较结果为false
+func parseDecimalRule(expectedValue, rule string) decimalRule {
+	r := decimalRule{rule: rule}
+	parts := ruleValueParts(expectedValue, rule)
+	if rule == "range" {
+		// expectedValue 应该是 "min,max" 格式
+		if len(parts) != 2 {
+			return r
+		}
+		min, err1 := decimal.NewFromString(parts[0])
+		max, err2 := decimal.NewFromString(parts[1])
+		r.min, r.max, r.valid = min, max, err1 == nil && err2 == nil
+		return r
+	}
+	expected, err := decimal.NewFromString(parts[0])
+	r.expected, r.valid = expected, err == nil
+	return r
+}
+
+// 拆分规则值，range规则按逗号拆分为最小值和最大值，忽略前后空格
+func ruleValueParts(value, rule string) []string {
+	parts := []string{value}
+	if rule == "range" {
+		parts = strings.Split(value, ",")
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+func (r decimalRule) check(actualValue decimal.Decimal) bool {
+	if !r.valid {
+		return false
+	}
+	switch r.rule {
+	case "eq":
+		return actualValue.Equal(r.expected)
+	case "gt":
+		return actualValue.GreaterThan(r.expected)
+	case "gte":
+		return actualValue.GreaterThanOrEqual(r.expected)
+	case "lt":
+		return actualValue.LessThan(r.expected)
+	case "lte":
+		return actualValue.LessThanOrEqual(r.expected)
+	case "range":
+		return actualValue.GreaterThanOrEqual(r.min) && actualValue.LessThanOrEqual(r.max)
+	default:
+		return actualValue.Equal(r.expected)
+	}
+}
+
+// path中的一级，数字同时可作为数组索引
+type pathKey struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// 按点号拆分路径
+func parsePath(path string) []pathKey {
+	names := strings.Split(path, ".")
+	keys := make([]pathKey, len(names))
+	for i, name := range names {
+		index, err := strconv.Atoi(name)
+		keys[i] = pathKey{name: name, index: index, isIndex: err == nil}
+	}
+	return keys
+}
+
+// 地址列表按链规范化后的地址集合，第一次检查该链时生成
+type addressSetCache struct {
+	mu   sync.Mutex
+	sets map[string]map[string]bool
+}
+
+func (c *addressSetCache) get(chain string, list []string) map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if set, ok := c.sets[chain]; ok {
+		return set
+	}
+	if c.sets == nil {
+		c.sets = map[string]map[string]bool{}
+	}
+	set := addressSet(chain, list)
+	c.sets[chain] = set
+	return set
+}
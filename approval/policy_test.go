@@ -0,0 +1,273 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompilePolicy(t *testing.T) {
+	resources := NewPolicyResources()
+	assert.NoError(t, resources.RegisterAddressList("policy-test", []string{"0x00000000000000000000000000000000000000aa"}))
+
+	txInfo := map[string]interface{}{
+		"chain":     "ETH",
+		"to":        "0x00000000000000000000000000000000000000AA",
+		"value":     "0.3",
+		"amount":    "100.50",
+		"contracts": []interface{}{"contract1", "contract2"},
+		"nested":    map[string]interface{}{"data": []interface{}{map[string]interface{}{"address": "0xabc"}}},
+	}
+
+	t.Run("与TraceParam结果一致", func(t *testing.T) {
+		params := []VerifyParams{
+			{Path: "chain", Value: "ETH", Rule: "exact"},
+			{Path: "to", Value: "^0x0+aa$", Rule: "regex"},
+			{Path: "to", Value: "(", Rule: "regex"},
+			{Path: "amount", Value: "100,200", Rule: "range"},
+			{Path: "amount", Value: "abc", Rule: "lt"},
+			{Path: "value", Value: "0.5 ETH", Rule: "lt"},
+			{Path: "value", Value: "1 SOL", Rule: "lt"},
+			{Path: "to", Value: "policy-test", Rule: "inList"},
+			{Path: "contracts", Value: "3", Rule: "minLength"},
+			{Path: "nested.data.-1.address", Value: "0xabc", Rule: "exact"},
+			{Path: "missing.0", Value: "1", Rule: "eq"},
+			{Any: []VerifyParams{{Path: "chain", Value: "BSC"}, {Not: &VerifyParams{Path: "amount", Value: "100", Rule: "lt"}}}},
+		}
+		for _, param := range params {
+			compiled := compileParam(param, resources)
+			expected := compileParam(param, resources).trace(txInfo)
+			assert.Equal(t, expected, compiled.trace(txInfo), param.Path)
+			// 重复检查结果不变
+			assert.Equal(t, expected, compiled.trace(txInfo), param.Path)
+			if param.Rule != "inList" {
+				assert.Equal(t, TraceParam(txInfo, param), expected, param.Path)
+			}
+		}
+	})
+
+	t.Run("匹配和检查", func(t *testing.T) {
+		policy := CompilePolicy([]ApprovalParams{
+			{MatchParams: []VerifyParams{{Path: "chain", Value: "BSC", Rule: "exact"}}},
+			{
+				MatchParams:  []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}},
+				VerifyParams: []VerifyParams{{Path: "value", Value: "0.1 ETH", Rule: "lt"}, {Path: "amount", Value: "1000", Rule: "lt"}},
+			},
+		})
+		trace := &DecisionTrace{}
+		assert.Equal(t, 1, policy.match(txInfo, trace))
+		assert.Len(t, trace.Match, 2)
+		assert.Equal(t, DecisionReject, policy.decide(1, txInfo, trace))
+		assert.Equal(t, "verify param value failed: comparison failed", trace.Reason)
+		assert.Len(t, trace.Verify, 2)
+	})
+
+	t.Run("编译后修改资源不影响规则", func(t *testing.T) {
+		resources := NewPolicyResources()
+		assert.NoError(t, resources.RegisterAddressList("policy-test", []string{"0x00000000000000000000000000000000000000aa"}))
+		policy := CompilePolicyWithResources([]ApprovalParams{{MatchParams: []VerifyParams{{Path: "to", Value: "policy-test", Rule: "inList"}}}}, resources)
+		assert.NoError(t, policy.Validate())
+		assert.NoError(t, resources.RegisterAddressList("policy-test", []string{"0x00000000000000000000000000000000000000bb"}))
+		assert.Equal(t, 0, policy.match(txInfo, &DecisionTrace{}))
+
+		updated := CompilePolicyWithResources(policy.Params, resources)
+		assert.NotEqual(t, policy.Version, updated.Version)
+		assert.Equal(t, -1, updated.match(txInfo, &DecisionTrace{}))
+	})
+
+	t.Run("钱包规则变更后重新编译", func(t *testing.T) {
+		w := NewApprovalWallet("key", "secret")
+		policy := w.Policy()
+		assert.Same(t, policy, w.Policy())
+		w.SetApprovalParams(append(w.ApprovalParams, ApprovalParams{MatchParams: []VerifyParams{{Path: "chain", Value: "ETH"}}}))
+		assert.NotSame(t, policy, w.Policy())
+		assert.Equal(t, 0, w.Policy().match(txInfo, &DecisionTrace{}))
+
+		// 直接修改已有规则的字段，调用SetApprovalParams后生效
+		policy = w.Policy()
+		w.ApprovalParams[0].MatchParams[0].Value = "BSC"
+		assert.Equal(t, "ETH", policy.Params[0].MatchParams[0].Value)
+		assert.Same(t, policy, w.Policy())
+		w.SetApprovalParams(w.ApprovalParams)
+		assert.NotSame(t, policy, w.Policy())
+		assert.Equal(t, -1, w.Policy().match(txInfo, &DecisionTrace{}))
+		assert.Same(t, w.Policy(), w.Policy())
+	})
+
+	t.Run("决策不受编译后修改Params影响", func(t *testing.T) {
+		policy := CompilePolicy([]ApprovalParams{{
+			MatchParams:  []VerifyParams{{Path: "chain", Value: "ETH"}},
+			VerifyParams: []VerifyParams{{Path: "amount", Value: "1", Rule: "lt", OnFail: DecisionSkip}},
+		}})
+		policy.Params[0].VerifyParams[0].OnFail = DecisionReject
+		assert.Equal(t, DecisionSkip, policy.decide(0, map[string]interface{}{"chain": "ETH", "amount": "10"}, &DecisionTrace{}))
+		policy.Params[0].Action = DecisionReject
+		assert.Equal(t, DecisionApprove, policy.decide(0, map[string]interface{}{"chain": "ETH", "amount": "0.1"}, &DecisionTrace{}))
+	})
+}
+
+func TestReloadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	policyPath := filepath.Join(dir, "policy.json")
+	listPath := filepath.Join(dir, "lists", "treasury.csv")
+	assert.NoError(t, os.Mkdir(filepath.Dir(listPath), 0755))
+	assert.NoError(t, os.WriteFile(listPath, []byte("0x00000000000000000000000000000000000000aa\n"), 0644))
+	// 相对路径相对于配置文件所在目录
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{"role": "approver", "storePath": "store.json", "policyFile": "policy.json", "addressLists": [{"name": "treasury", "file": "lists/treasury.csv"}]}`), 0644))
+	writePolicy := func(value string) {
+		policy := `{"approvalParams": [{"matchParams": [{"path": "chain", "value": "ETH", "rule": "exact"}], "verifyParams": [{"path": "amount", "value": "` + value + `", "rule": "lt"}, {"path": "to", "value": "treasury", "rule": "inList"}]}]}`
+		assert.NoError(t, os.WriteFile(policyPath, []byte(policy), 0644))
+	}
+
+	writePolicy("10")
+	w, err := NewApprovalWalletFromJson(configPath)
+	assert.NoError(t, err)
+	version := w.Policy().Version
+	assert.Len(t, version, 12)
+	assert.Equal(t, "10", w.ApprovalParams[0].VerifyParams[0].Value)
+	assert.Equal(t, policyPath, w.PolicyFile)
+	assert.Equal(t, filepath.Join(dir, "store.json"), w.Client.Store.Path())
+
+	t.Run("文件未修改", func(t *testing.T) {
+		changed, err := w.ReloadPolicy(configPath)
+		assert.NoError(t, err)
+		assert.False(t, changed)
+	})
+
+	t.Run("无效规则保留原来的规则", func(t *testing.T) {
+		writePolicy("ten")
+		changed, err := w.ReloadPolicy(configPath)
+		var errs PolicyErrors
+		assert.True(t, errors.As(err, &errs))
+		assert.Equal(t, 1, errs[0].Line)
+		assert.False(t, changed)
+		assert.Equal(t, version, w.Policy().Version)
+
+		// 文件未再修改时不重复报错
+		changed, err = w.ReloadPolicy(configPath)
+		assert.NoError(t, err)
+		assert.False(t, changed)
+	})
+
+	t.Run("替换规则并记录版本", func(t *testing.T) {
+		writePolicy("1000")
+		changed, err := w.ReloadPolicy(configPath)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.NotEqual(t, version, w.Policy().Version)
+		assert.Equal(t, "1000", w.ApprovalParams[0].VerifyParams[0].Value)
+
+		api := approvaltest.NewFakeApi()
+		id := api.AddApproval("", "TRANSACTION", apisdk.TXInfo{Chain: "ETH", To: "0x00000000000000000000000000000000000000AA", Value: "100"})
+		w.Client = NewClientWithApi("key", "secret", api)
+		results, err := AutoApprovePolicyContext(context.Background(), w.Client, w.Policy())
+		assert.NoError(t, err)
+		assert.Equal(t, w.Policy().Version, results[0].Trace.PolicyVersion)
+		r, _ := api.Record(id)
+		assert.Equal(t, approvaltest.StatusAgree, r.Status)
+	})
+
+	t.Run("地址列表文件修改后重新加载", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "to": "0x00000000000000000000000000000000000000bb", "amount": "1"}
+		assert.Equal(t, DecisionReject, w.Policy().Evaluate(txInfo).Decision)
+		version := w.Policy().Version
+
+		assert.NoError(t, os.WriteFile(listPath, []byte("0x00000000000000000000000000000000000000aa\n0x00000000000000000000000000000000000000bb\n"), 0644))
+		changed, err := w.ReloadPolicy(configPath)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.NotEqual(t, version, w.Policy().Version)
+		assert.Equal(t, DecisionApprove, w.Policy().Evaluate(txInfo).Decision)
+	})
+
+	t.Run("配置中的地址列表修改后重新加载", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "to": "0x00000000000000000000000000000000000000cc", "amount": "1"}
+		assert.NoError(t, os.WriteFile(configPath, []byte(`{"role": "approver", "policyFile": "policy.json", "addressLists": [{"name": "treasury", "addresses": ["0x00000000000000000000000000000000000000cc"]}]}`), 0644))
+		changed, err := w.ReloadPolicy(configPath)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, DecisionApprove, w.Policy().Evaluate(txInfo).Decision)
+		assert.Empty(t, w.AddressLists[0].File)
+	})
+}
+
+// 大量规则和审批记录，只有最后一个规则匹配
+func benchmarkPolicyData(rules, records int) ([]ApprovalParams, []map[string]interface{}) {
+	var params []ApprovalParams
+	for i := 0; i < rules; i++ {
+		params = append(params, ApprovalParams{
+			MatchParams: []VerifyParams{
+				{Path: "chain", Value: "ETH", Rule: "exact"},
+				{Path: "to", Value: fmt.Sprintf("^0x%040x$", i), Rule: "regex"},
+			},
+			VerifyParams: []VerifyParams{
+				{Path: "value", Value: "0,1.5", Rule: "range"},
+				{Path: "transfer.amount", Value: "1000", Rule: "lte"},
+				{Any: []VerifyParams{
+					{Path: "from", Value: "0x1", Rule: "prefix"},
+					{Path: "contracts", Value: "5", Rule: "maxLength"},
+				}},
+			},
+		})
+	}
+	var txInfos []map[string]interface{}
+	for i := 0; i < records; i++ {
+		txInfos = append(txInfos, map[string]interface{}{
+			"chain":     "ETH",
+			"from":      "0x2",
+			"to":        fmt.Sprintf("0x%040x", rules-1),
+			"value":     "1.2",
+			"transfer":  map[string]interface{}{"amount": fmt.Sprint(i)},
+			"contracts": []interface{}{"a", "b"},
+		})
+	}
+	return params, txInfos
+}
+
+func BenchmarkPolicy(b *testing.B) {
+	params, txInfos := benchmarkPolicyData(100, 100)
+
+	b.Run("每次解析", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, txInfo := range txInfos {
+				for i, rule := range params {
+					matched := true
+					for _, param := range rule.MatchParams {
+						if !TraceParam(txInfo, param).Passed {
+							matched = false
+							break
+						}
+					}
+					if matched {
+						for _, param := range params[i].VerifyParams {
+							TraceParam(txInfo, param)
+						}
+						break
+					}
+				}
+			}
+		}
+	})
+
+	b.Run("预编译", func(b *testing.B) {
+		policy := CompilePolicy(params)
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			for _, txInfo := range txInfos {
+				trace := &DecisionTrace{}
+				if i := policy.match(txInfo, trace); i >= 0 {
+					policy.decide(i, txInfo, trace)
+				}
+			}
+		}
+	})
+}
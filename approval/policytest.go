@@ -0,0 +1,109 @@
+package approval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+)
+
+// 审批规则测试用例，每个json文件一个用例
+type PolicyFixture struct {
+	Name   string          // 用例名称，为空时使用文件名
+	TxInfo json.RawMessage // 审批记录中的txinfo，同决策日志中的txInfo
+	Note   string          // 发起审批时的备注
+	Expect PolicyExpect
+}
+
+// 期望的决策结果，为空的字段不检查
+type PolicyExpect struct {
+	MatchedIndex *int   // 匹配的ApprovalParams序号，-1为未匹配
+	Decision     string // approve/reject/skip
+}
+
+// 单个测试用例的结果
+type PolicyTestResult struct {
+	File     string
+	Name     string
+	Passed   bool
+	Failures []string // 与期望不一致的地方
+	Trace    *DecisionTrace
+	Err      error // 用例文件错误
+}
+
+/*
+  - 使用目录下的所有json测试用例检查审批规则
+    txInfo按审批接口返回的结构转换后评估，不检查VelocityLimits
+    返回值: 按文件名排序的测试结果，目录读取失败时返回错误
+*/
+func RunPolicyTests(policy *Policy, dir string) ([]PolicyTestResult, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no fixture found in %s", dir)
+	}
+	sort.Strings(files)
+
+	var results []PolicyTestResult
+	for _, file := range files {
+		results = append(results, runPolicyTest(policy, file))
+	}
+	return results, nil
+}
+
+func runPolicyTest(policy *Policy, file string) PolicyTestResult {
+	result := PolicyTestResult{File: file, Name: strings.TrimSuffix(filepath.Base(file), ".json")}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	var fixture PolicyFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		result.Err = err
+		return result
+	}
+	if fixture.Name != "" {
+		result.Name = fixture.Name
+	}
+	expect := fixture.Expect
+	if expect.MatchedIndex == nil && expect.Decision == "" {
+		result.Err = fmt.Errorf("expect matchedIndex or decision is required")
+		return result
+	}
+	txInfo, err := parseTxInfo(fixture.TxInfo)
+	if err != nil {
+		result.Err = fmt.Errorf("invalid txInfo: %w", err)
+		return result
+	}
+
+	trace := policy.EvaluateNote(txInfo, fixture.Note)
+	result.Trace = trace
+	if expect.MatchedIndex != nil && *expect.MatchedIndex != trace.MatchedIndex {
+		result.Failures = append(result.Failures, fmt.Sprintf("expected matchedIndex %d, got %d", *expect.MatchedIndex, trace.MatchedIndex))
+	}
+	if expect.Decision != "" && expect.Decision != trace.Decision {
+		result.Failures = append(result.Failures, fmt.Sprintf("expected decision %s, got %s", expect.Decision, trace.Decision))
+	}
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+// 按审批接口返回的txinfo结构解析，与自动审批时的字段一致
+func parseTxInfo(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("txInfo is required")
+	}
+	var resp apisdk.RespApprovals
+	body := `{"data": [{"extra_data": {"txinfo": ` + string(data) + `}}]}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data[0].ExtraData.Txinfo, nil
+}
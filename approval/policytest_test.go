@@ -0,0 +1,47 @@
+package approval
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPolicyTests(t *testing.T) {
+	policy := CompilePolicy([]ApprovalParams{{
+		MatchParams:  []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}},
+		VerifyParams: []VerifyParams{{Path: "amount", Value: "10", Rule: "lt"}},
+	}})
+	dir := t.TempDir()
+	fixtures := map[string]string{
+		"a-approve.json": `{"txInfo": {"chain": "ETH", "amount": "1"}, "expect": {"matchedIndex": 0, "decision": "approve"}}`,
+		"b-wrong.json":   `{"name": "大额转账", "txInfo": {"chain": "ETH", "amount": "100"}, "expect": {"matchedIndex": -1, "decision": "approve"}}`,
+		"c-skip.json":    `{"txInfo": {"chain": "BSC", "amount": "1", "unknownField": "x"}, "expect": {"decision": "skip"}}`,
+		"d-empty.json":   `{"txInfo": {"chain": "ETH"}}`,
+		"e-invalid.json": `{"txInfo": "ETH", "expect": {"decision": "skip"}}`,
+		"readme.txt":     `not a fixture`,
+	}
+	for name, content := range fixtures {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	results, err := RunPolicyTests(policy, dir)
+	assert.NoError(t, err)
+	assert.Len(t, results, 5)
+
+	assert.True(t, results[0].Passed)
+	assert.Equal(t, "a-approve", results[0].Name)
+
+	assert.False(t, results[1].Passed)
+	assert.Equal(t, "大额转账", results[1].Name)
+	assert.Equal(t, []string{"expected matchedIndex -1, got 0", "expected decision approve, got reject"}, results[1].Failures)
+	assert.Equal(t, "verify param amount failed: comparison failed", results[1].Trace.Reason)
+
+	assert.True(t, results[2].Passed)
+	assert.EqualError(t, results[3].Err, "expect matchedIndex or decision is required")
+	assert.ErrorContains(t, results[4].Err, "invalid txInfo")
+
+	_, err = RunPolicyTests(policy, t.TempDir())
+	assert.ErrorContains(t, err, "no fixture found")
+}
@@ -0,0 +1,58 @@
+package approval
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+/*
+  - 审批规则用到的资源：合约ABI、token精度、地址列表
+    编译规则时复制到Policy中，不同的Policy、ApprovalWallet之间互不影响，修改后需要重新编译规则
+    注册方法不是并发安全的，需要在编译规则前完成注册
+*/
+type PolicyResources struct {
+	methods map[[4]byte]abi.Method // 按4字节selector索引的合约方法
+	tokens  map[string]Token       // 按大写symbol索引的token
+	lists   map[string][]string    // 按名称索引的地址列表
+	sources []string               // 注册的资源内容，用于计算规则版本
+}
+
+// 创建资源，包含内置的常用合约方法
+func NewPolicyResources() *PolicyResources {
+	r := &PolicyResources{methods: map[[4]byte]abi.Method{}, tokens: map[string]Token{}, lists: map[string][]string{}}
+	for id, method := range builtinEvmMethods() {
+		r.methods[id] = method
+	}
+	return r
+}
+
+func (r *PolicyResources) clone() *PolicyResources {
+	c := &PolicyResources{
+		methods: make(map[[4]byte]abi.Method, len(r.methods)),
+		tokens:  make(map[string]Token, len(r.tokens)),
+		lists:   make(map[string][]string, len(r.lists)),
+		sources: append([]string(nil), r.sources...),
+	}
+	for id, method := range r.methods {
+		c.methods[id] = method
+	}
+	for symbol, token := range r.tokens {
+		c.tokens[symbol] = token
+	}
+	for name, list := range r.lists {
+		c.lists[name] = list
+	}
+	return c
+}
+
+// 注册资源内容的hash，没有注册资源时为空
+func (r *PolicyResources) digest() string {
+	if r == nil || len(r.sources) == 0 {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(strings.Join(r.sources, "\n")))
+	return hex.EncodeToString(hash[:])
+}
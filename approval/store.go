@@ -0,0 +1,254 @@
+package approval
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 本地持久化存储，保存需要跨进程重启保留的数据，如累计额度、待签名队列
+// path为空时只保存在内存中
+type Store struct {
+	path string
+	mu   sync.Mutex
+	data storeData
+}
+
+type storeData struct {
+	Spends       []SpendRecord `json:"spends"`
+	PendingSigns []PendingSign `json:"pendingSigns"`
+	Initiations  []Initiation  `json:"initiations"`
+}
+
+// 已审批通过的额度记录
+type SpendRecord struct {
+	Key        string    `json:"key"`
+	ApprovalId string    `json:"approvalId"`
+	Value      string    `json:"value"`
+	Time       time.Time `json:"time"`
+	ExpireAt   time.Time `json:"expireAt"`
+}
+
+// 已审批通过但docker还未确认签名的记录
+type PendingSign struct {
+	ApprovalId string    `json:"approvalId"`
+	Method     string    `json:"method"` // docker签名接口: sign_message/sign_transaction/send_transaction
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"lastError,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// 按调用方提供的幂等key发起的审批
+type Initiation struct {
+	Key        string          `json:"key"`
+	TxInfoHash string          `json:"txInfoHash"`       // 发起时txInfo和审批选项的hash，同一个key只能用于相同的txInfo和审批选项
+	Handle     *ApprovalHandle `json:"handle,omitempty"` // NewApproval返回前为空，为空时可能已经创建了审批
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+/*
+  - 打开本地存储
+    @path: 存储文件路径，文件不存在时自动创建，为空时只保存在内存中
+*/
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.data); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// 存储文件路径，为空时只保存在内存中
+func (s *Store) Path() string {
+	return s.path
+}
+
+// 查询key在now之前未过期的额度记录
+func (s *Store) Spends(key string, now time.Time) []SpendRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []SpendRecord
+	for _, r := range s.data.Spends {
+		if r.Key == key && r.ExpireAt.After(now) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// 添加额度记录，同时清理已过期的记录
+func (s *Store) AddSpends(records []SpendRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	spends := s.data.Spends[:0]
+	for _, r := range s.data.Spends {
+		if r.ExpireAt.After(now) {
+			spends = append(spends, r)
+		}
+	}
+	s.data.Spends = append(spends, records...)
+	return s.save()
+}
+
+// 删除审批的所有额度记录，审批确定失败时释放已占用的额度
+func (s *Store) RemoveSpends(approvalId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spends := s.data.Spends[:0]
+	for _, r := range s.data.Spends {
+		if r.ApprovalId != approvalId {
+			spends = append(spends, r)
+		}
+	}
+	if len(spends) == len(s.data.Spends) {
+		return nil
+	}
+	s.data.Spends = spends
+	return s.save()
+}
+
+// 待签名队列，按加入顺序返回
+func (s *Store) PendingSigns() []PendingSign {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PendingSign(nil), s.data.PendingSigns...)
+}
+
+// 添加或更新待签名记录，按approvalId去重
+func (s *Store) PutPendingSign(p PendingSign) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.data.PendingSigns {
+		if s.data.PendingSigns[i].ApprovalId == p.ApprovalId {
+			s.data.PendingSigns[i] = p
+			return s.save()
+		}
+	}
+	s.data.PendingSigns = append(s.data.PendingSigns, p)
+	return s.save()
+}
+
+// 签名确认后从队列中移除
+func (s *Store) RemovePendingSign(approvalId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.data.PendingSigns {
+		if s.data.PendingSigns[i].ApprovalId == approvalId {
+			s.data.PendingSigns = append(s.data.PendingSigns[:i], s.data.PendingSigns[i+1:]...)
+			return s.save()
+		}
+	}
+	return nil
+}
+
+// 查询幂等key对应的发起记录
+func (s *Store) Initiation(key string) (Initiation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, i := range s.data.Initiations {
+		if i.Key == key {
+			return i, true
+		}
+	}
+	return Initiation{}, false
+}
+
+// 添加或更新发起记录，按key去重，可以用于手动指定中断时已创建的审批
+func (s *Store) PutInitiation(i Initiation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for j := range s.data.Initiations {
+		if s.data.Initiations[j].Key == i.Key {
+			s.data.Initiations[j] = i
+			return s.save()
+		}
+	}
+	s.data.Initiations = append(s.data.Initiations, i)
+	return s.save()
+}
+
+// 删除发起记录，确认中断时没有创建审批后，可以删除后重新发起
+func (s *Store) RemoveInitiation(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.data.Initiations {
+		if s.data.Initiations[i].Key == key {
+			s.data.Initiations = append(s.data.Initiations[:i], s.data.Initiations[i+1:]...)
+			return s.save()
+		}
+	}
+	return nil
+}
+
+// key不存在时保存发起记录并返回true，已存在时返回已有的记录
+func (s *Store) reserveInitiation(i Initiation) (Initiation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.data.Initiations {
+		if existing.Key == i.Key {
+			return existing, false, nil
+		}
+	}
+	s.data.Initiations = append(s.data.Initiations, i)
+	if err := s.save(); err != nil {
+		s.data.Initiations = s.data.Initiations[:len(s.data.Initiations)-1]
+		return Initiation{}, false, err
+	}
+	return i, true, nil
+}
+
+// 写入临时文件后替换，避免进程中断导致文件损坏，调用方需持有锁
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
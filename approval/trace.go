@@ -0,0 +1,94 @@
+package approval
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+)
+
+// 审批决策，也用于ApprovalParams.Action和VerifyParams.OnFail
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+	DecisionSkip    = "skip"
+)
+
+// 单个审批记录的决策过程
+type DecisionTrace struct {
+	ApprovalId    string       `json:"approvalId"`
+	PolicyVersion string       `json:"policyVersion,omitempty"` // 使用的规则版本，Policy.Version
+	MatchedIndex  int          `json:"matchedIndex"`            // 匹配到的ApprovalParams序号，-1为未匹配
+	Match         []MatchTrace `json:"match"`
+	Verify        []ParamTrace `json:"verify,omitempty"`
+	Decision      string       `json:"decision"`
+	Escalated     bool         `json:"escalated,omitempty"` // 匹配后转人工审批
+	Reason        string       `json:"reason,omitempty"`
+}
+
+// 单个ApprovalParams的MatchParams匹配结果
+type MatchTrace struct {
+	Index   int          `json:"index"`
+	Matched bool         `json:"matched"`
+	Params  []ParamTrace `json:"params"`
+}
+
+// 单个条件的检查结果
+type ParamTrace struct {
+	Group    string       `json:"group,omitempty"` // 条件组合: all/any/not
+	Path     string       `json:"path,omitempty"`
+	Value    string       `json:"value,omitempty"`
+	Rule     string       `json:"rule,omitempty"`
+	Actual   interface{}  `json:"actual,omitempty"` // getValueByPath取到的实际值
+	Passed   bool         `json:"passed"`
+	Reason   string       `json:"reason,omitempty"`
+	Children []ParamTrace `json:"children,omitempty"`
+}
+
+// 检查参数并返回检查过程，只支持原生币单位，使用token等资源时使用CompilePolicyWithResources
+// 每次调用都会重新解析参数，多次检查同一组规则时使用CompilePolicy
+func TraceParam(txInfo map[string]interface{}, param VerifyParams) ParamTrace {
+	return compileParam(param, NewPolicyResources()).trace(txInfo)
+}
+
+func stringFailReason(actual string, regexErr error, rule string) string {
+	switch rule {
+	case "regex":
+		if regexErr != nil {
+			return fmt.Sprintf("invalid regex: %v", regexErr)
+		}
+	case "eq", "gt", "gte", "lt", "lte", "range":
+		return fmt.Sprintf("parse failure: %q is not a number", actual)
+	}
+	return "comparison failed"
+}
+
+func decimalFailReason(expectedValue, rule string) string {
+	parts := ruleValueParts(expectedValue, rule)
+	if rule == "range" {
+		if len(parts) != 2 {
+			return fmt.Sprintf("invalid range %q", expectedValue)
+		}
+		for _, part := range parts {
+			if _, err := decimal.NewFromString(part); err != nil {
+				return fmt.Sprintf("invalid range %q", expectedValue)
+			}
+		}
+	} else if _, err := decimal.NewFromString(parts[0]); err != nil {
+		return fmt.Sprintf("parse failure: rule value %q is not a number", expectedValue)
+	}
+	return "comparison failed"
+}
+
+func listFailReason(expectedValue, rule string) string {
+	switch rule {
+	case "length", "minLength", "maxLength":
+		if _, err := strconv.Atoi(expectedValue); err != nil {
+			return fmt.Sprintf("parse failure: rule value %q is not a number", expectedValue)
+		}
+	case "contains", "notContains":
+	default:
+		return fmt.Sprintf("unknown list rule %q", rule)
+	}
+	return "comparison failed"
+}
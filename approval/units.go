@@ -0,0 +1,224 @@
+package approval
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// 链原生币单位
+type nativeUnit struct {
+	kind     string // evm/solana
+	symbol   string // 为空时适用于同类型的所有链，如wei、gwei
+	decimals int32  // 相对最小单位的精度
+}
+
+var nativeUnits = map[string]nativeUnit{
+	"WEI":      {kind: "evm", decimals: 0},
+	"GWEI":     {kind: "evm", decimals: 9},
+	"ETHER":    {kind: "evm", decimals: 18},
+	"ETH":      {kind: "evm", symbol: "ETH", decimals: 18},
+	"BNB":      {kind: "evm", symbol: "BNB", decimals: 18},
+	"POL":      {kind: "evm", symbol: "POL", decimals: 18},
+	"MATIC":    {kind: "evm", symbol: "POL", decimals: 18},
+	"AVAX":     {kind: "evm", symbol: "AVAX", decimals: 18},
+	"FTM":      {kind: "evm", symbol: "FTM", decimals: 18},
+	"LAMPORTS": {kind: "solana", decimals: 0},
+	"SOL":      {kind: "solana", symbol: "SOL", decimals: 9},
+}
+
+var chainNativeSymbols = map[string]string{
+	strings.ToUpper(ETHEREUM):  "ETH",
+	strings.ToUpper(ARBITRUM):  "ETH",
+	strings.ToUpper(OPTIMISM):  "ETH",
+	strings.ToUpper(BSC):       "BNB",
+	strings.ToUpper(POLYGON):   "POL",
+	strings.ToUpper(AVALANCHE): "AVAX",
+	strings.ToUpper(FANTOM):    "FTM",
+	strings.ToUpper(SOLANA):    "SOL",
+}
+
+// token精度配置，用于规则值中的token单位，如 "1000 USDC"
+type Token struct {
+	Symbol    string
+	Decimals  int32
+	Addresses []string // token合约地址或mint地址，必填，转换单位时校验交易中的token地址
+}
+
+// 注册token精度，相同symbol时覆盖
+func (r *PolicyResources) RegisterToken(token Token) error {
+	symbol := strings.ToUpper(strings.TrimSpace(token.Symbol))
+	if symbol == "" {
+		return fmt.Errorf("token symbol is required")
+	}
+	if _, ok := nativeUnits[symbol]; ok {
+		return fmt.Errorf("token symbol %s conflicts with native unit", token.Symbol)
+	}
+	if token.Decimals < 0 || token.Decimals > 36 {
+		return fmt.Errorf("token %s: invalid decimals %d", token.Symbol, token.Decimals)
+	}
+	if len(token.Addresses) == 0 {
+		return fmt.Errorf("token %s: addresses is required", token.Symbol)
+	}
+	r.tokens[symbol] = token
+	data, _ := json.Marshal(token)
+	r.sources = append(r.sources, "token:"+string(data))
+	return nil
+}
+
+func (r *PolicyResources) lookupToken(symbol string) (Token, bool) {
+	token, ok := r.tokens[strings.ToUpper(symbol)]
+	return token, ok
+}
+
+// 实际值的单位
+type fieldUnit struct {
+	native       *nativeUnit // 原生币，精度为native.decimals
+	token        bool        // token的可读单位，如 1.5 USDC
+	base         bool        // token的最小单位，如calldata中的amount
+	tokenAddress string      // 交易中的token地址，为空时无法确认token，token单位转换失败
+}
+
+var quantityRe = regexp.MustCompile(`^\s*([-+]?[0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)\s+([A-Za-z][A-Za-z0-9._-]*)\s*$`)
+
+// 将带单位的规则值转换为实际值的单位，不带单位时原样返回，range规则的两个值分别转换
+// token单位使用resources中注册的token
+func convertUnitValue(resources *PolicyResources, txInfo map[string]interface{}, param VerifyParams) (string, error) {
+	parts := ruleValueParts(param.Value, param.Rule)
+	var field *fieldUnit
+	for i, part := range parts {
+		match := quantityRe.FindStringSubmatch(part)
+		if match == nil {
+			continue
+		}
+		if field == nil {
+			f, err := resolveFieldUnit(txInfo, param)
+			if err != nil {
+				return "", err
+			}
+			field = f
+		}
+		amount, err := decimal.NewFromString(match[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid amount %q", match[1])
+		}
+		converted, err := field.convert(resources, txInfo, amount, match[2])
+		if err != nil {
+			return "", err
+		}
+		parts[i] = converted.String()
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (f *fieldUnit) convert(resources *PolicyResources, txInfo map[string]interface{}, amount decimal.Decimal, unit string) (decimal.Decimal, error) {
+	chain, _ := txInfo["chain"].(string)
+	if native, ok := nativeUnits[strings.ToUpper(unit)]; ok {
+		if f.native == nil {
+			return amount, fmt.Errorf("unit %s is a native unit, but the value is a token amount", unit)
+		}
+		if native.kind != f.native.kind {
+			return amount, fmt.Errorf("unit %s is not supported on chain %s", unit, chain)
+		}
+		if native.symbol != "" && native.symbol != chainNativeSymbols[strings.ToUpper(chain)] {
+			return amount, fmt.Errorf("unit %s is not the native token of chain %s", unit, chain)
+		}
+		return amount.Shift(native.decimals - f.native.decimals), nil
+	}
+
+	token, ok := resources.lookupToken(unit)
+	if !ok {
+		return amount, fmt.Errorf("unknown unit %s", unit)
+	}
+	if f.native != nil {
+		return amount, fmt.Errorf("unit %s is a token, but the value is a native amount", unit)
+	}
+	// 只有确认交易中的token与配置一致时才按token精度转换，避免其他token按相同symbol通过
+	if len(token.Addresses) == 0 {
+		return amount, fmt.Errorf("token %s has no addresses configured", token.Symbol)
+	}
+	if f.tokenAddress == "" {
+		return amount, fmt.Errorf("token address of the value is unknown, unable to verify %s", token.Symbol)
+	}
+	if !containsAddress(token.Addresses, f.tokenAddress) {
+		return amount, fmt.Errorf("token address %s does not match %s", f.tokenAddress, token.Symbol)
+	}
+	if f.base {
+		return amount.Shift(token.Decimals), nil
+	}
+	return amount, nil
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if a == address || (strings.HasPrefix(a, "0x") && strings.EqualFold(a, address)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 确定path对应实际值的单位，param.Unit不为空时使用配置的单位，否则按链和path推断
+func resolveFieldUnit(txInfo map[string]interface{}, param VerifyParams) (*fieldUnit, error) {
+	chain, _ := txInfo["chain"].(string)
+	if param.Unit != "" {
+		switch strings.ToLower(param.Unit) {
+		case "token":
+			tokenAddress, _ := txInfo["tokenAddress"].(string)
+			return &fieldUnit{token: true, tokenAddress: tokenAddress}, nil
+		case "base":
+			return &fieldUnit{base: true, tokenAddress: decodedTokenAddress(txInfo, param.Path)}, nil
+		}
+		native, ok := nativeUnits[strings.ToUpper(param.Unit)]
+		if !ok {
+			return nil, fmt.Errorf("unknown unit %s of path %s", param.Unit, param.Path)
+		}
+		return &fieldUnit{native: &native}, nil
+	}
+
+	keys := strings.Split(param.Path, ".")
+	last := keys[len(keys)-1]
+	switch {
+	case keys[0] == decodedKey && last == "lamports":
+		native := nativeUnits["LAMPORTS"]
+		return &fieldUnit{native: &native}, nil
+	case keys[0] == decodedKey:
+		return &fieldUnit{base: true, tokenAddress: decodedTokenAddress(txInfo, param.Path)}, nil
+	case isEvmChain(chain) && (param.Path == "gasPrice" || param.Path == "maxFeePerGas" || param.Path == "maxPriorityFeePerGas"):
+		native := nativeUnits["GWEI"]
+		return &fieldUnit{native: &native}, nil
+	case (param.Path == "value" || param.Path == "amount") && (isEvmChain(chain) || strings.EqualFold(chain, SOLANA)):
+		if tokenAddress, _ := txInfo["tokenAddress"].(string); tokenAddress != "" {
+			return &fieldUnit{token: true, tokenAddress: tokenAddress}, nil
+		}
+		native := nativeUnits["ETHER"]
+		if strings.EqualFold(chain, SOLANA) {
+			native = nativeUnits["SOL"]
+		}
+		return &fieldUnit{native: &native}, nil
+	}
+	return nil, fmt.Errorf("unknown unit of path %s on chain %s, set unit in rule", param.Path, chain)
+}
+
+// 解码后的token金额对应的token地址：EVM为调用的合约target，Solana为指令参数中的mint
+func decodedTokenAddress(txInfo map[string]interface{}, path string) string {
+	keys := strings.Split(path, ".")
+	for i := len(keys) - 1; i > 0; i-- {
+		m, ok := getValueByPath(txInfo, strings.Join(keys[:i], ".")).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if args, ok := m["args"].(map[string]interface{}); ok {
+			if mint, ok := args["mint"].(string); ok {
+				return mint
+			}
+		}
+		if target, ok := m["target"].(string); ok {
+			return target
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package approval
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitRuleValue(t *testing.T) {
+	resources := NewPolicyResources()
+	assert.NoError(t, resources.RegisterToken(Token{Symbol: "USDC", Decimals: 6, Addresses: []string{
+		"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48",
+		"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v",
+	}}))
+	assert.Error(t, resources.RegisterToken(Token{Symbol: "gwei", Decimals: 9}))
+	assert.Error(t, resources.RegisterToken(Token{Symbol: "BAD", Decimals: -1}))
+	assert.ErrorContains(t, resources.RegisterToken(Token{Symbol: "DAI", Decimals: 18}), "addresses is required")
+	traceParam := func(txInfo map[string]interface{}, param VerifyParams) ParamTrace {
+		return compileParam(param, resources).trace(txInfo)
+	}
+	checkParam := func(txInfo map[string]interface{}, param VerifyParams) bool {
+		return traceParam(txInfo, param).Passed
+	}
+
+	evmTx := map[string]interface{}{
+		"chain":    "ETH",
+		"value":    "0.3",
+		"gasPrice": "30",
+		"decoded": map[string]interface{}{
+			"target": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+			"args":   map[string]interface{}{"amount": "1500000000"},
+		},
+	}
+	solanaTx := map[string]interface{}{
+		"chain": "Solana",
+		"decoded": map[string]interface{}{
+			"instructions": []interface{}{
+				map[string]interface{}{"args": map[string]interface{}{"lamports": "500000000"}},
+				map[string]interface{}{"args": map[string]interface{}{
+					"mint":   "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v",
+					"amount": "2000000",
+				}},
+			},
+		},
+	}
+
+	t.Run("原生币单位", func(t *testing.T) {
+		assert.True(t, checkParam(evmTx, VerifyParams{Path: "value", Value: "0.5 ETH", Rule: "lt"}))
+		assert.True(t, checkParam(evmTx, VerifyParams{Path: "value", Value: "300000000 gwei", Rule: "eq"}))
+		assert.True(t, checkParam(evmTx, VerifyParams{Path: "gasPrice", Value: "100 gwei", Rule: "lte"}))
+		assert.False(t, checkParam(evmTx, VerifyParams{Path: "gasPrice", Value: "0.00000002 ether", Rule: "lte"}))
+		assert.True(t, checkParam(solanaTx, VerifyParams{Path: "decoded.instructions.0.args.lamports", Value: "1 SOL", Rule: "lt"}))
+		assert.True(t, checkParam(solanaTx, VerifyParams{Path: "decoded.instructions.0.args.lamports", Value: "0.1 SOL,0.5 SOL", Rule: "range"}))
+	})
+
+	t.Run("token单位", func(t *testing.T) {
+		assert.True(t, checkParam(evmTx, VerifyParams{Path: "decoded.args.amount", Value: "1500 USDC", Rule: "eq"}))
+		assert.False(t, checkParam(evmTx, VerifyParams{Path: "decoded.args.amount", Value: "1000 usdc", Rule: "lte"}))
+		assert.True(t, checkParam(solanaTx, VerifyParams{Path: "decoded.instructions.1.args.amount", Value: "2 USDC", Rule: "eq"}))
+
+		tokenTx := map[string]interface{}{"chain": "ETH", "value": "12.5", "tokenAddress": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"}
+		assert.True(t, checkParam(tokenTx, VerifyParams{Path: "value", Value: "100 USDC", Rule: "lt"}))
+	})
+
+	t.Run("不带单位保持原有比较", func(t *testing.T) {
+		assert.True(t, checkParam(evmTx, VerifyParams{Path: "decoded.args.amount", Value: "1500000000", Rule: "eq"}))
+	})
+
+	t.Run("单位错误", func(t *testing.T) {
+		dai := map[string]interface{}{
+			"chain":   "ETH",
+			"decoded": map[string]interface{}{"target": "0x6b175474e89094c44da98b954eedeac495271d0f", "args": map[string]interface{}{"amount": "1"}},
+		}
+		cases := []struct {
+			txInfo map[string]interface{}
+			param  VerifyParams
+			reason string
+		}{
+			{evmTx, VerifyParams{Path: "value", Value: "1 SOL", Rule: "lt"}, "not supported on chain ETH"},
+			{map[string]interface{}{"chain": "BSC", "value": "1"}, VerifyParams{Path: "value", Value: "1 ETH", Rule: "lt"}, "not the native token of chain BSC"},
+			{evmTx, VerifyParams{Path: "value", Value: "1 USDC", Rule: "lt"}, "is a token"},
+			{evmTx, VerifyParams{Path: "decoded.args.amount", Value: "1 ETH", Rule: "lt"}, "is a native unit"},
+			{evmTx, VerifyParams{Path: "decoded.args.amount", Value: "1 DAI", Rule: "lt"}, "unknown unit DAI"},
+			{dai, VerifyParams{Path: "decoded.args.amount", Value: "1 USDC", Rule: "lt"}, "does not match USDC"},
+			{map[string]interface{}{"chain": "ETH", "decoded": map[string]interface{}{"args": map[string]interface{}{"amount": "1"}}}, VerifyParams{Path: "decoded.args.amount", Value: "1 USDC", Rule: "lt"}, "token address of the value is unknown"},
+			{map[string]interface{}{"chain": "ETH", "fee": "1"}, VerifyParams{Path: "fee", Value: "1 USDC", Rule: "lt", Unit: "token"}, "token address of the value is unknown"},
+			{map[string]interface{}{"chain": "ETH", "nonce": "1"}, VerifyParams{Path: "nonce", Value: "1 ETH", Rule: "lt"}, "unknown unit of path nonce"},
+		}
+		for _, c := range cases {
+			trace := traceParam(c.txInfo, c.param)
+			assert.False(t, trace.Passed, c.param.Value)
+			assert.Contains(t, trace.Reason, c.reason)
+		}
+	})
+
+	t.Run("指定实际值单位", func(t *testing.T) {
+		txInfo := map[string]interface{}{"chain": "ETH", "fee": "21000000000000"}
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "fee", Value: "0.001 ETH", Rule: "lt", Unit: "wei"}))
+		assert.True(t, checkParam(txInfo, VerifyParams{Path: "fee", Value: "21000 gwei", Rule: "eq", Unit: "wei"}))
+	})
+
+	t.Run("累计限制", func(t *testing.T) {
+		limits := []VelocityLimit{{Name: "eth", Window: "1h", ValuePath: "value", MaxValue: "0.5 ETH"}}
+		store := &Store{}
+		spends, err := checkVelocityLimits(resources, store, limits, "a", evmTx, time.Now())
+		assert.NoError(t, err)
+		assert.NoError(t, store.AddSpends(spends))
+		_, err = checkVelocityLimits(resources, store, limits, "b", evmTx, time.Now())
+		assert.ErrorContains(t, err, "exceeded")
+	})
+
+	t.Run("token只对注册的资源生效", func(t *testing.T) {
+		param := VerifyParams{Path: "decoded.args.amount", Value: "1500 USDC", Rule: "eq"}
+		assert.False(t, CheckParam(evmTx, param))
+		assert.Contains(t, TraceParam(evmTx, param).Reason, "unknown unit USDC")
+		assert.Error(t, ValidateApprovalParams([]ApprovalParams{{VerifyParams: []VerifyParams{param}}}))
+		assert.NoError(t, CompilePolicyWithResources([]ApprovalParams{{VerifyParams: []VerifyParams{param}}}, resources).Validate())
+	})
+}
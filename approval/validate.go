@@ -0,0 +1,326 @@
+package approval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 支持的规则
+var (
+	stringRules  = []string{"exact", "contains", "prefix", "suffix", "regex"}
+	numericRules = []string{"eq", "gt", "gte", "lt", "lte", "range"}
+	listRules    = []string{"length", "minLength", "maxLength", "contains", "notContains"}
+	addressRules = []string{"inList", "notInList"}
+	actions      = []string{DecisionApprove, DecisionReject, DecisionSkip}
+)
+
+// 审批规则配置错误
+type PolicyError struct {
+	Path    string // 配置中的位置，如 approvalParams[0].verifyParams[1]
+	Line    int    // 配置文件中的行号，0为未知
+	Message string
+}
+
+func (e PolicyError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// 所有审批规则配置错误
+type PolicyErrors []PolicyError
+
+func (e PolicyErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d invalid approval params:\n  %s", len(e), strings.Join(lines, "\n  "))
+}
+
+/*
+  - 校验审批规则：规则名称、数值、正则、path格式、单位和地址列表
+    只支持原生币单位，使用token时通过CompilePolicyWithResources编译后调用Policy.Validate
+    返回值: 所有错误，类型为PolicyErrors，无错误时返回nil
+*/
+func ValidateApprovalParams(params []ApprovalParams) error {
+	return CompilePolicy(params).Validate()
+}
+
+// 使用编译时的资源校验审批规则，返回值同ValidateApprovalParams
+func (p *Policy) Validate() error {
+	if errs := validateApprovalParams(p.Params, p.resources); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateApprovalParams(params []ApprovalParams, resources *PolicyResources) PolicyErrors {
+	var errs PolicyErrors
+	add := func(path, format string, args ...interface{}) {
+		errs = append(errs, PolicyError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var validateParam func(path string, p VerifyParams)
+	validateParam = func(path string, p VerifyParams) {
+		if p.OnFail != "" && p.OnFail != DecisionReject && p.OnFail != DecisionSkip {
+			add(path, "unknown onFail %q", p.OnFail)
+		}
+		if !p.isGroup() || p.Path != "" || p.Rule != "" {
+			for _, msg := range validateLeaf(resources, p) {
+				add(path, "%s", msg)
+			}
+		}
+		for i, child := range p.All {
+			validateParam(fmt.Sprintf("%s.all[%d]", path, i), child)
+		}
+		for i, child := range p.Any {
+			validateParam(fmt.Sprintf("%s.any[%d]", path, i), child)
+		}
+		if p.Not != nil {
+			validateParam(path+".not", *p.Not)
+		}
+	}
+
+	for i, policy := range params {
+		prefix := fmt.Sprintf("approvalParams[%d]", i)
+		if policy.Action != "" && !containsString(actions, policy.Action) {
+			add(prefix, "unknown action %q", policy.Action)
+		}
+		for j, p := range policy.MatchParams {
+			validateParam(fmt.Sprintf("%s.matchParams[%d]", prefix, j), p)
+		}
+		for j, p := range policy.VerifyParams {
+			validateParam(fmt.Sprintf("%s.verifyParams[%d]", prefix, j), p)
+		}
+		for j, limit := range policy.VelocityLimits {
+			path := fmt.Sprintf("%s.velocityLimits[%d]", prefix, j)
+			if limit.Name == "" {
+				add(path, "name is required")
+			}
+			if window, err := time.ParseDuration(limit.Window); err != nil || window <= 0 {
+				add(path, "invalid window %q", limit.Window)
+			}
+			for _, groupBy := range limit.GroupBy {
+				if msg := validatePath(groupBy); msg != "" {
+					add(path, "groupBy %s", msg)
+				}
+			}
+			if limit.ValuePath != "" {
+				if msg := validatePath(limit.ValuePath); msg != "" {
+					add(path, "valuePath %s", msg)
+				}
+			}
+			if limit.MaxValue != "" {
+				if msg := validateNumber(resources, limit.MaxValue); msg != "" {
+					add(path, "maxValue %s", msg)
+				}
+			}
+			if limit.MaxCount < 0 {
+				add(path, "invalid maxCount %d", limit.MaxCount)
+			}
+		}
+	}
+	return errs
+}
+
+// 解码结果中只在部分交易中存在的字段，规则中使用时输出警告
+var partialDecodedKeys = map[string]string{
+	"destinationOwner": "destinationOwner is only decoded when the token account is created in the same transaction, transfers to existing token accounts fail with path not found",
+}
+
+// 规则中可能不符合预期的配置，不影响加载，加载配置时输出到日志
+func policyWarnings(params []ApprovalParams) PolicyErrors {
+	var warnings PolicyErrors
+	checkPath := func(path, paramPath string) {
+		for _, key := range strings.Split(paramPath, ".") {
+			if msg, ok := partialDecodedKeys[key]; ok {
+				warnings = append(warnings, PolicyError{Path: path, Message: msg})
+			}
+		}
+	}
+
+	var walk func(path string, p VerifyParams)
+	walk = func(path string, p VerifyParams) {
+		checkPath(path, p.Path)
+		for i, child := range p.All {
+			walk(fmt.Sprintf("%s.all[%d]", path, i), child)
+		}
+		for i, child := range p.Any {
+			walk(fmt.Sprintf("%s.any[%d]", path, i), child)
+		}
+		if p.Not != nil {
+			walk(path+".not", *p.Not)
+		}
+	}
+
+	for i, policy := range params {
+		prefix := fmt.Sprintf("approvalParams[%d]", i)
+		for j, p := range policy.MatchParams {
+			walk(fmt.Sprintf("%s.matchParams[%d]", prefix, j), p)
+		}
+		for j, p := range policy.VerifyParams {
+			walk(fmt.Sprintf("%s.verifyParams[%d]", prefix, j), p)
+		}
+		for j, limit := range policy.VelocityLimits {
+			for _, groupBy := range limit.GroupBy {
+				checkPath(fmt.Sprintf("%s.velocityLimits[%d]", prefix, j), groupBy)
+			}
+		}
+	}
+	return warnings
+}
+
+// 校验单个Path/Value/Rule条件
+func validateLeaf(resources *PolicyResources, p VerifyParams) []string {
+	var msgs []string
+	if msg := validatePath(p.Path); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	if p.Unit != "" {
+		if _, ok := nativeUnits[strings.ToUpper(p.Unit)]; !ok && !strings.EqualFold(p.Unit, "token") && !strings.EqualFold(p.Unit, "base") {
+			msgs = append(msgs, fmt.Sprintf("unknown unit %q", p.Unit))
+		}
+	}
+
+	switch {
+	case p.Rule == "":
+		msgs = append(msgs, "rule is required")
+	case p.Rule == "regex":
+		if _, err := regexp.Compile(p.Value); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid regex %q: %v", p.Value, err))
+		}
+	case p.Rule == "range":
+		parts := ruleValueParts(p.Value, p.Rule)
+		if len(parts) != 2 {
+			msgs = append(msgs, fmt.Sprintf("invalid range %q, expected min,max", p.Value))
+			break
+		}
+		for _, part := range parts {
+			if msg := validateNumber(resources, part); msg != "" {
+				msgs = append(msgs, "range "+msg)
+			}
+		}
+		min, err1 := decimal.NewFromString(parts[0])
+		max, err2 := decimal.NewFromString(parts[1])
+		if err1 == nil && err2 == nil && min.GreaterThan(max) {
+			msgs = append(msgs, fmt.Sprintf("invalid range %q, min is greater than max", p.Value))
+		}
+	case containsString(numericRules, p.Rule):
+		if msg := validateNumber(resources, p.Value); msg != "" {
+			msgs = append(msgs, msg)
+		}
+	case p.Rule == "length" || p.Rule == "minLength" || p.Rule == "maxLength":
+		if n, err := strconv.Atoi(p.Value); err != nil || n < 0 {
+			msgs = append(msgs, fmt.Sprintf("invalid %s %q, expected a non-negative integer", p.Rule, p.Value))
+		}
+	case containsString(addressRules, p.Rule):
+		if list, err := resources.lookupAddressLists(p.Value); err != nil {
+			msgs = append(msgs, err.Error())
+		} else if p.Rule == "inList" && len(list) == 0 {
+			msgs = append(msgs, fmt.Sprintf("address list %s is empty", p.Value))
+		}
+	case containsString(stringRules, p.Rule) || containsString(listRules, p.Rule):
+	default:
+		msgs = append(msgs, fmt.Sprintf("unknown rule %q", p.Rule))
+	}
+	return msgs
+}
+
+// path为.分割的非空字段，不能包含空白字符
+func validatePath(path string) string {
+	if path == "" {
+		return "path is required"
+	}
+	for _, key := range strings.Split(path, ".") {
+		if key == "" || strings.ContainsAny(key, " \t\r\n") {
+			return fmt.Sprintf("invalid path %q", path)
+		}
+	}
+	return ""
+}
+
+// 数值，可以带单位，如 0.5 ETH，token单位需要在resources中注册
+func validateNumber(resources *PolicyResources, value string) string {
+	if _, err := decimal.NewFromString(strings.TrimSpace(value)); err == nil {
+		return ""
+	}
+	match := quantityRe.FindStringSubmatch(value)
+	if match == nil {
+		return fmt.Sprintf("invalid number %q", value)
+	}
+	if _, ok := nativeUnits[strings.ToUpper(match[2])]; ok {
+		return ""
+	}
+	if _, ok := resources.lookupToken(match[2]); ok {
+		return ""
+	}
+	return fmt.Sprintf("unknown unit %q in %q", match[2], value)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 配置文件中每个json值所在的行号，key为小写的路径，如 approvalparams[0].verifyparams[1]
+func jsonLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		lines[strings.ToLower(path)] = 1 + bytes.Count(data[:dec.InputOffset()], []byte("\n"))
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				childPath, _ := key.(string)
+				if path != "" {
+					childPath = path + "." + childPath
+				}
+				if err := walk(childPath); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	walk("")
+	return lines
+}
+
+// 为配置错误添加行号
+func (e PolicyErrors) withLines(data []byte) PolicyErrors {
+	lines := jsonLines(data)
+	for i := range e {
+		e[i].Line = lines[strings.ToLower(e[i].Path)]
+	}
+	return e
+}
@@ -0,0 +1,113 @@
+package approval
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateApprovalParams(t *testing.T) {
+	t.Run("有效配置", func(t *testing.T) {
+		params := []ApprovalParams{{
+			MatchParams: []VerifyParams{{Path: "chain", Value: "ETH", Rule: "exact"}},
+			VerifyParams: []VerifyParams{
+				{Path: "value", Value: "0.5 ETH", Rule: "lte"},
+				{Path: "fee", Value: "1,2", Rule: "range"},
+				{Path: "to", Value: "^0x[0-9a-f]+$", Rule: "regex"},
+				{Any: []VerifyParams{{Path: "contracts", Value: "2", Rule: "maxLength"}}},
+			},
+			VelocityLimits: []VelocityLimit{{Name: "daily", Window: "1h", MaxValue: "10"}},
+		}}
+		assert.NoError(t, ValidateApprovalParams(params))
+	})
+
+	t.Run("汇总所有错误", func(t *testing.T) {
+		params := []ApprovalParams{{
+			MatchParams: []VerifyParams{{Path: "chain", Value: "ETH", Rule: "equals"}},
+			VerifyParams: []VerifyParams{
+				{Path: "amount", Value: "ten", Rule: "lt"},
+				{Path: "fee", Value: "2,1", Rule: "range"},
+				{Path: "to", Value: "(", Rule: "regex"},
+				{Path: "transfer..amount", Value: "1", Rule: "gt"},
+				{Not: &VerifyParams{Path: "to", Value: "missing", Rule: "inList"}},
+				{Path: "value", Value: "1 DOGE", Rule: "lt", Unit: "satoshi"},
+				{},
+				{Path: "amount", Value: "1", Rule: "lt", OnFail: "notify"},
+			},
+			VelocityLimits: []VelocityLimit{{Window: "1d", MaxCount: -1}},
+			Action:         "escalate",
+		}}
+		err := ValidateApprovalParams(params)
+		var errs PolicyErrors
+		assert.True(t, errors.As(err, &errs))
+
+		messages := map[string][]string{}
+		for _, e := range errs {
+			messages[e.Path] = append(messages[e.Path], e.Message)
+		}
+		assert.Equal(t, map[string][]string{
+			"approvalParams[0].matchParams[0]":      {`unknown rule "equals"`},
+			"approvalParams[0].verifyParams[0]":     {`invalid number "ten"`},
+			"approvalParams[0].verifyParams[1]":     {`invalid range "2,1", min is greater than max`},
+			"approvalParams[0].verifyParams[2]":     {"invalid regex \"(\": error parsing regexp: missing closing ): `(`"},
+			"approvalParams[0].verifyParams[3]":     {`invalid path "transfer..amount"`},
+			"approvalParams[0].verifyParams[4].not": {`address list "missing" not found`},
+			"approvalParams[0].verifyParams[5]":     {`unknown unit "satoshi"`, `unknown unit "DOGE" in "1 DOGE"`},
+			"approvalParams[0].verifyParams[6]":     {"path is required", "rule is required"},
+			"approvalParams[0].verifyParams[7]":     {`unknown onFail "notify"`},
+			"approvalParams[0].velocityLimits[0]":   {"name is required", `invalid window "1d"`, "invalid maxCount -1"},
+			"approvalParams[0]":                     {`unknown action "escalate"`},
+		}, messages)
+	})
+
+	t.Run("部分交易才有的解码字段输出警告", func(t *testing.T) {
+		params := []ApprovalParams{{
+			MatchParams: []VerifyParams{{Path: "chain", Value: "Solana", Rule: "exact"}},
+			VerifyParams: []VerifyParams{
+				{Path: "decoded.instructions.0.args.destination", Value: "treasury", Rule: "inList"},
+				{Any: []VerifyParams{{Path: "decoded.instructions.1.args.destinationOwner", Value: "0x1", Rule: "exact"}}},
+			},
+		}}
+		warnings := policyWarnings(params)
+		assert.Len(t, warnings, 1)
+		assert.Equal(t, "approvalParams[0].verifyParams[1].any[0]", warnings[0].Path)
+		assert.Contains(t, warnings[0].Message, "created in the same transaction")
+	})
+
+	t.Run("配置文件错误包含行号", func(t *testing.T) {
+		config := `{
+    "role": "approver",
+    "approvalParams": [
+        {
+            "matchParams": [
+                {"path": "chain", "value": "ETH", "rule": "exact"}
+            ],
+            "verifyParams": [
+                {"path": "amount", "value": "10", "rule": "lt"},
+                {
+                    "any": [
+                        {"path": "to", "value": "0x1", "rule": "exact"},
+                        {"path": "to", "value": "[", "rule": "regex"}
+                    ]
+                },
+                {"path": "amount", "value": "1", "rule": "between"}
+            ]
+        }
+    ]
+}`
+		path := filepath.Join(t.TempDir(), "config.json")
+		assert.NoError(t, os.WriteFile(path, []byte(config), 0644))
+
+		_, err := NewApprovalWalletFromJson(path)
+		var errs PolicyErrors
+		assert.True(t, errors.As(err, &errs))
+		assert.Len(t, errs, 2)
+		assert.Equal(t, 13, errs[0].Line)
+		assert.Equal(t, "approvalParams[0].verifyParams[1].any[1]", errs[0].Path)
+		assert.Equal(t, 16, errs[1].Line)
+		assert.Contains(t, err.Error(), `line 16: approvalParams[0].verifyParams[2]: unknown rule "between"`)
+	})
+}
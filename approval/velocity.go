@@ -0,0 +1,137 @@
+package approval
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 累计额度/频率限制，统计时间窗口内已自动审批通过的记录
+type VelocityLimit struct {
+	Name      string   // 限制名称，必填，作为统计key的前缀，规则调整顺序后统计不受影响，相同名称的限制共用统计
+	Window    string   // 时间窗口，如 1h、24h
+	GroupBy   []string // 分组统计的txInfo路径，如 chain、tokenAddress、to
+	ValuePath string   // 金额路径，默认 amount
+	MaxValue  string   // 窗口内累计金额上限，为空不限制，支持带单位如 10 ETH，金额按链和token分开统计
+	MaxCount  int      // 窗口内审批次数上限，0不限制
+}
+
+// 检查本次审批是否超出限制，未超出时返回审批通过后需要记录的额度，maxValue的token单位使用resources中的token
+func checkVelocityLimits(resources *PolicyResources, store *Store, limits []VelocityLimit, approvalId string, txInfo map[string]interface{}, now time.Time) ([]SpendRecord, error) {
+	var records []SpendRecord
+	for i, limit := range limits {
+		if limit.Name == "" {
+			return nil, fmt.Errorf("velocity limit %d: name is required", i)
+		}
+		window, err := time.ParseDuration(limit.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("velocity limit %s: invalid window %q", limit.Name, limit.Window)
+		}
+
+		valuePath := limit.ValuePath
+		if valuePath == "" {
+			valuePath = "amount"
+		}
+		key := limit.key(txInfo)
+		if limit.MaxValue != "" {
+			// 不同链、token的金额单位不同，不能累加
+			unit, err := valueUnit(txInfo, valuePath)
+			if err != nil {
+				return nil, fmt.Errorf("velocity limit %s: %v", key, err)
+			}
+			key += "|unit=" + unit
+		}
+		spends := store.Spends(key, now)
+		counted := false
+		for _, r := range spends {
+			if r.ApprovalId == approvalId { // 已统计过的审批，不重复计算
+				counted = true
+				break
+			}
+		}
+		if counted {
+			continue
+		}
+
+		if limit.MaxCount > 0 && len(spends)+1 > limit.MaxCount {
+			return nil, fmt.Errorf("velocity limit %s exceeded: count %d, max %d", key, len(spends)+1, limit.MaxCount)
+		}
+
+		value := decimal.Zero
+		if v, ok := getValueByPath(txInfo, valuePath).(string); ok {
+			if value, err = decimal.NewFromString(v); err != nil {
+				value = decimal.Zero
+			}
+		} else {
+			err = fmt.Errorf("path %s not found", valuePath)
+		}
+
+		if limit.MaxValue != "" {
+			if err != nil { // 无法获取金额时视为超限
+				return nil, fmt.Errorf("velocity limit %s: invalid value: %v", key, err)
+			}
+			// maxValue支持带单位，如 "10 ETH"
+			maxValueStr, err := convertUnitValue(resources, txInfo, VerifyParams{Path: valuePath, Value: limit.MaxValue})
+			if err != nil {
+				return nil, fmt.Errorf("velocity limit %s: %v", key, err)
+			}
+			maxValue, err := decimal.NewFromString(maxValueStr)
+			if err != nil {
+				return nil, fmt.Errorf("velocity limit %s: invalid maxValue %q", key, limit.MaxValue)
+			}
+			total := value
+			for _, r := range spends {
+				if v, err := decimal.NewFromString(r.Value); err == nil {
+					total = total.Add(v)
+				}
+			}
+			if total.GreaterThan(maxValue) {
+				return nil, fmt.Errorf("velocity limit %s exceeded: total %s, max %s", key, total, maxValue)
+			}
+		}
+
+		records = append(records, SpendRecord{
+			Key:        key,
+			ApprovalId: approvalId,
+			Value:      value.String(),
+			Time:       now,
+			ExpireAt:   now.Add(window),
+		})
+	}
+	return records, nil
+}
+
+// 金额的单位: 链/native或链/token地址，无法确定单位时返回错误
+func valueUnit(txInfo map[string]interface{}, valuePath string) (string, error) {
+	chain, _ := txInfo["chain"].(string)
+	field, err := resolveFieldUnit(txInfo, VerifyParams{Path: valuePath})
+	if err != nil {
+		return "", err
+	}
+	if field.native != nil {
+		return chain + "/native", nil
+	}
+	if field.tokenAddress == "" {
+		return "", fmt.Errorf("token address of %s is unknown, unable to sum the value", valuePath)
+	}
+	tokenAddress, err := normalizeAddress(chain, field.tokenAddress)
+	if err != nil {
+		return "", err
+	}
+	return chain + "/" + tokenAddress, nil
+}
+
+// 统计key: 名称|分组路径=值
+func (l VelocityLimit) key(txInfo map[string]interface{}) string {
+	parts := []string{l.Name}
+	for _, path := range l.GroupBy {
+		value := getValueByPath(txInfo, path)
+		if value == nil {
+			value = ""
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", path, value))
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,112 @@
+package approval
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVelocityLimits(t *testing.T) {
+	now := time.Now()
+	txInfo := map[string]interface{}{
+		"chain":  "ETH",
+		"to":     "0xabc",
+		"amount": "40",
+	}
+
+	t.Run("累计金额超限", func(t *testing.T) {
+		store := &Store{}
+		limits := []VelocityLimit{{Name: "amount", Window: "1h", GroupBy: []string{"chain", "to"}, MaxValue: "100"}}
+
+		for i, id := range []string{"r1", "r2"} {
+			records, err := checkVelocityLimits(NewPolicyResources(), store, limits, id, txInfo, now.Add(time.Duration(i)*time.Minute))
+			assert.NoError(t, err)
+			assert.Len(t, records, 1)
+			assert.Equal(t, "amount|chain=ETH|to=0xabc|unit=ETH/native", records[0].Key)
+			assert.NoError(t, store.AddSpends(records))
+		}
+
+		_, err := checkVelocityLimits(NewPolicyResources(), store, limits, "r3", txInfo, now.Add(2*time.Minute))
+		assert.ErrorContains(t, err, "exceeded")
+
+		// 不同分组单独统计
+		other := map[string]interface{}{"chain": "ETH", "to": "0xdef", "amount": "40"}
+		_, err = checkVelocityLimits(NewPolicyResources(), store, limits, "r3", other, now.Add(2*time.Minute))
+		assert.NoError(t, err)
+
+		// 窗口过期后重新统计
+		_, err = checkVelocityLimits(NewPolicyResources(), store, limits, "r3", txInfo, now.Add(61*time.Minute))
+		assert.NoError(t, err)
+	})
+
+	t.Run("不同链和token的金额分开统计", func(t *testing.T) {
+		store := &Store{}
+		limits := []VelocityLimit{{Name: "amount", Window: "1h", MaxValue: "50"}}
+		records, err := checkVelocityLimits(NewPolicyResources(), store, limits, "r1", txInfo, now)
+		assert.NoError(t, err)
+		assert.NoError(t, store.AddSpends(records))
+
+		for i, tx := range []map[string]interface{}{
+			{"chain": "Solana", "amount": "40"},
+			{"chain": "ETH", "amount": "40", "tokenAddress": "0xdAC17F958D2ee523a2206206994597C13D831ec7"},
+		} {
+			records, err := checkVelocityLimits(NewPolicyResources(), store, limits, fmt.Sprintf("o%d", i), tx, now)
+			assert.NoError(t, err)
+			assert.NoError(t, store.AddSpends(records))
+		}
+		assert.Equal(t, "amount|unit=ETH/0xdac17f958d2ee523a2206206994597c13d831ec7", store.data.Spends[2].Key)
+		_, err = checkVelocityLimits(NewPolicyResources(), store, limits, "r2", txInfo, now)
+		assert.ErrorContains(t, err, "exceeded")
+
+		// 无法确定单位时不统计金额
+		_, err = checkVelocityLimits(NewPolicyResources(), store, []VelocityLimit{{Name: "fee", Window: "1h", ValuePath: "fee", MaxValue: "1"}}, "r3", map[string]interface{}{"chain": "ETH", "fee": "1"}, now)
+		assert.ErrorContains(t, err, "unknown unit of path fee")
+	})
+
+	t.Run("审批次数超限", func(t *testing.T) {
+		store := &Store{}
+		limits := []VelocityLimit{{Name: "count", Window: "24h", MaxCount: 1}}
+
+		records, err := checkVelocityLimits(NewPolicyResources(), store, limits, "r1", txInfo, now)
+		assert.NoError(t, err)
+		assert.NoError(t, store.AddSpends(records))
+
+		_, err = checkVelocityLimits(NewPolicyResources(), store, limits, "r2", txInfo, now)
+		assert.ErrorContains(t, err, "count 2, max 1")
+
+		// 已统计过的审批不重复计算
+		records, err = checkVelocityLimits(NewPolicyResources(), store, limits, "r1", txInfo, now)
+		assert.NoError(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("无效配置", func(t *testing.T) {
+		_, err := checkVelocityLimits(NewPolicyResources(), &Store{}, []VelocityLimit{{Name: "l", Window: "1d"}}, "r1", txInfo, now)
+		assert.Error(t, err)
+
+		_, err = checkVelocityLimits(NewPolicyResources(), &Store{}, []VelocityLimit{{Name: "l", Window: "1h", ValuePath: "value", MaxValue: "1"}}, "r1", txInfo, now)
+		assert.Error(t, err)
+
+		_, err = checkVelocityLimits(NewPolicyResources(), &Store{}, []VelocityLimit{{Window: "1h", MaxCount: 1}}, "r1", txInfo, now)
+		assert.ErrorContains(t, err, "name is required")
+	})
+}
+
+func TestStorePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := NewStore(path)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	assert.NoError(t, store.AddSpends([]SpendRecord{
+		{Key: "k", ApprovalId: "r1", Value: "1", Time: now, ExpireAt: now.Add(time.Hour)},
+	}))
+
+	reopened, err := NewStore(path)
+	assert.NoError(t, err)
+	assert.Len(t, reopened.Spends("k", now), 1)
+	assert.Empty(t, reopened.Spends("k", now.Add(2*time.Hour)))
+}
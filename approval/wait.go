@@ -0,0 +1,220 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	apisdk "github.com/OpenBlockResource/openblock-api-sdk-go"
+)
+
+var (
+	ErrApprovalRejected = errors.New("approval rejected")
+	ErrApprovalTimeout  = errors.New("approve timeout")
+)
+
+// 已发起的审批，可以序列化保存，Action、Chain、BridgeMethod为空时使用审批记录中的值
+type ApprovalHandle struct {
+	RecordId     string `json:"recordId"`
+	Action       string `json:"action,omitempty"` // TRANSACTION/TRANSACTION_CONTRACT_INTERACTION/TRANSACTION_SIGNATURE
+	Chain        string `json:"chain,omitempty"`
+	BridgeMethod string `json:"bridgeMethod,omitempty"` // 只签名不发送交易时为*_signTransaction
+}
+
+// 审批当前状态
+type ApprovalStatus struct {
+	RecordId string
+	Status   string // ING/AGREE/REJECT，查询不到审批记录时为空
+	Done     bool   // 审批已通过或拒绝
+	Result   string // 审批通过时的txHash/签名/rawTx
+	Err      error  // 审批被拒绝或签名结果无效
+}
+
+// 等待审批结果的查询间隔和超时时间，为0的字段使用默认值
+type WaitOptions struct {
+	Interval    time.Duration // 查询间隔，默认3s
+	Multiplier  float64       // 每次查询后间隔乘以Multiplier，小于等于1时间隔不变
+	MaxInterval time.Duration // 最大查询间隔，为0时不限制
+	Timeout     time.Duration // 最长等待时间，默认90s，小于0时不限制，只受ctx控制
+}
+
+var DefaultWaitOptions = WaitOptions{Interval: 3 * time.Second, Timeout: 90 * time.Second}
+
+func (o WaitOptions) withDefaults() WaitOptions {
+	if o.Interval <= 0 {
+		o.Interval = DefaultWaitOptions.Interval
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultWaitOptions.Timeout
+	}
+	return o
+}
+
+func (o WaitOptions) next(interval time.Duration) time.Duration {
+	if o.Multiplier > 1 {
+		interval = time.Duration(float64(interval) * o.Multiplier)
+	}
+	if o.MaxInterval > 0 && interval > o.MaxInterval {
+		interval = o.MaxInterval
+	}
+	return interval
+}
+
+// 查询审批状态，审批通过时返回签名结果
+func GetApprovalStatus(client *Client, handle *ApprovalHandle) (*ApprovalStatus, error) {
+	return GetApprovalStatusContext(context.Background(), client, handle)
+}
+
+func GetApprovalStatusContext(ctx context.Context, client *Client, handle *ApprovalHandle) (*ApprovalStatus, error) {
+	apprs, err := client.GetSponsoredApprovalsContext(ctx, handle.RecordId)
+	if err != nil {
+		return nil, err
+	}
+	for _, appr := range apprs.Data.Data {
+		if appr.RecordID == handle.RecordId {
+			return approvalStatus(handle, appr.Status, appr.ActionType, appr.TxHash, appr.ExtraData), nil
+		}
+	}
+	return &ApprovalStatus{RecordId: handle.RecordId}, nil
+}
+
+// 按审批记录生成审批状态，handle中为空的字段使用审批记录中的值
+func approvalStatus(handle *ApprovalHandle, status, actionType, txHash string, extra apisdk.ExtraData) *ApprovalStatus {
+	result := &ApprovalStatus{RecordId: handle.RecordId, Status: status}
+	switch status {
+	case StatusAgree:
+		h := *handle
+		if h.Action == "" {
+			h.Action = actionType
+		}
+		if h.Chain == "" {
+			h.Chain = extra.Txinfo.Chain
+		}
+		if h.BridgeMethod == "" {
+			h.BridgeMethod = extra.Txinfo.BridgeMethod
+		}
+		result.Done = true
+		result.Result, result.Err = approvalResult(&h, txHash, extra)
+	case StatusReject:
+		result.Done = true
+		result.Err = fmt.Errorf("%w, recordId: %s", ErrApprovalRejected, handle.RecordId)
+	}
+	return result
+}
+
+/*
+  - 等待审批结果，按opts间隔查询，直到审批通过、拒绝、超时或ctx取消
+    超时返回ErrApprovalTimeout，审批仍然存在，可以再次调用继续等待
+    返回值: txHash/签名/rawTx
+*/
+func WaitApproval(client *Client, handle *ApprovalHandle, opts WaitOptions) (string, error) {
+	return WaitApprovalContext(context.Background(), client, handle, opts)
+}
+
+func WaitApprovalContext(ctx context.Context, client *Client, handle *ApprovalHandle, opts WaitOptions) (string, error) {
+	var status *ApprovalStatus
+	err := pollApprovals(ctx, opts, "recordId: "+handle.RecordId, func() (bool, error) {
+		var err error
+		status, err = GetApprovalStatusContext(ctx, client, handle)
+		return err == nil && status.Done, err
+	})
+	if errors.Is(err, ErrApprovalTimeout) {
+		return "", fmt.Errorf("%w, recordId: %s, approval is still pending", ErrApprovalTimeout, handle.RecordId)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w, recordId: %s", err, handle.RecordId)
+	}
+	return status.Result, status.Err
+}
+
+/*
+  - 按opts间隔调用poll查询审批状态，直到poll返回done、超时或ctx取消
+    poll返回临时错误时继续等待，审批已经创建，不中断
+    返回值: 超时返回ErrApprovalTimeout，查询失败或ctx取消时返回对应的错误
+*/
+func pollApprovals(ctx context.Context, opts WaitOptions, desc string, poll func() (bool, error)) error {
+	opts = opts.withDefaults()
+	start := time.Now()
+	interval := opts.Interval
+	for {
+		done, err := poll()
+		if err != nil && !IsRetryable(err) {
+			return fmt.Errorf("GetSponsoredApprovals error: %w", err)
+		}
+		if done {
+			return nil
+		}
+		if err != nil { //临时错误继续等待
+			log.Printf("GetSponsoredApprovals error, %s, %v", desc, err)
+		}
+
+		wait := interval
+		if opts.Timeout > 0 {
+			remaining := opts.Timeout - time.Since(start)
+			if remaining <= 0 {
+				return ErrApprovalTimeout
+			}
+			wait = min(wait, remaining)
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return fmt.Errorf("wait approval: %w", err)
+		}
+		interval = opts.next(interval)
+	}
+}
+
+// 审批通过后的结果: 发送交易为txHash，只签名为rawTx，消息签名为签名
+func approvalResult(handle *ApprovalHandle, txHash string, extra apisdk.ExtraData) (string, error) {
+	recordId := handle.RecordId
+	res := txHash
+	if strings.HasSuffix(handle.BridgeMethod, "_signTransaction") { //只签名不发送交易
+		if extra.CustomData == "" {
+			return "", fmt.Errorf("customData is empty, recordId: %s", recordId)
+		}
+
+		var resData any
+		var rawTx string
+		json.Unmarshal([]byte(extra.CustomData), &resData)
+		if v, ok := resData.(map[string]any); ok && v["data"] != nil {
+			var valid bool
+			if vv, ok := v["data"].(string); ok {
+				rawTx, valid = vv, true
+			} else if vv, ok := v["data"].([]any); ok && len(vv) > 0 {
+				rawTx, valid = vv[0].(string)
+			}
+			if !valid {
+				return "", fmt.Errorf("invalid customData, recordId: %s", recordId)
+			}
+		}
+		if rawTx == "" {
+			return "", fmt.Errorf("rawTx is empty, recordId: %s", recordId)
+		}
+
+		switch handle.Chain {
+		case BENFEN, BENFEN_TESTNET:
+			var suiTxData []any
+			json.Unmarshal([]byte(rawTx), &suiTxData)
+			if len(suiTxData) != 4 {
+				return "", fmt.Errorf("invalid benfen tx data, recordId: %s", recordId)
+			}
+			sigs, ok := suiTxData[1].([]any)
+			if !ok || len(sigs) == 0 {
+				return "", fmt.Errorf("invalid benfen tx data, recordId: %s", recordId)
+			}
+			if res, ok = sigs[0].(string); !ok {
+				return "", fmt.Errorf("invalid benfen tx data, recordId: %s", recordId)
+			}
+		}
+
+	} else if handle.Action == "TRANSACTION_SIGNATURE" && extra.Authorization != nil {
+		res = extra.Authorization.FinalHash
+	}
+	if res == "" {
+		return "", fmt.Errorf("sign result is empty")
+	}
+	return res, nil
+}
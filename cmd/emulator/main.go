@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval/approvaltest"
+)
+
+// 本地模拟openblock企业钱包接口和docker签名接口，runner配置apiUrl和dockerPort指向模拟服务后离线运行
+func main() {
+	addr := flag.String("addr", "localhost:7790", "Address the emulator listens on, serves both the OpenBlock API and the docker signer")
+	scriptPath := flag.String("script", "", "Path to the emulator script json")
+	apiKey := flag.String("api-key", "", "Verify the api_key and sign of requests when set, used with -api-secret")
+	apiSecret := flag.String("api-secret", "", "Api secret used to verify the sign of requests")
+	flag.Parse()
+
+	server, err := approvaltest.NewServerAt(nil, *addr)
+	if err != nil {
+		log.Fatalf("Failed to start emulator at %s: %v", *addr, err)
+	}
+	defer server.Close()
+	server.ApiKey = *apiKey
+	server.ApiSecret = *apiSecret
+	if *scriptPath != "" {
+		script, err := approvaltest.LoadScript(*scriptPath)
+		if err != nil {
+			log.Fatalf("Failed to load emulator script from %s: %v", *scriptPath, err)
+		}
+		if err := server.SetScript(script); err != nil {
+			log.Fatalf("Invalid emulator script: %v", err)
+		}
+	}
+	log.Printf("OpenBlock emulator listening at %s", server.URL)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval"
+)
+
+const policyUsage = `Usage:
+  runner policy test [-policy <file>] [-fixtures <dir>]
+  runner policy export -config <file> -history <file> [-status AGREE,REJECT]
+  runner policy replay -policy <file> -history <file> [-json]`
+
+// runner policy子命令，返回退出码
+func runPolicyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, policyUsage)
+		return 2
+	}
+	switch args[0] {
+	case "test":
+		return runPolicyTest(args[1:])
+	case "export":
+		return runPolicyExport(args[1:])
+	case "replay":
+		return runPolicyReplay(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, policyUsage)
+	return 2
+}
+
+// 使用测试用例检查审批规则，全部通过时返回0
+func runPolicyTest(args []string) int {
+	fs := flag.NewFlagSet("policy test", flag.ExitOnError)
+	// 默认使用仓库中的示例规则和测试用例，在仓库根目录执行
+	policyPath := fs.String("policy", "cmd/manager.json", "Path to the config or policy file with approvalParams")
+	fixtures := fs.String("fixtures", "cmd/fixtures", "Directory of fixture json files with txInfo and expected outcome")
+	verbose := fs.Bool("v", false, "Print the decision trace of failed fixtures")
+	fs.Parse(args)
+
+	policy, err := approval.LoadPolicyFile(*policyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load policy: %v\n", err)
+		return 1
+	}
+	results, err := approval.RunPolicyTests(policy, *fixtures)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to run policy tests: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			failed++
+			fmt.Printf("ERROR %s: %v\n", res.Name, res.Err)
+		case !res.Passed:
+			failed++
+			fmt.Printf("FAIL  %s: %v, reason: %s\n", res.Name, res.Failures, res.Trace.Reason)
+			if *verbose {
+				trace, _ := json.MarshalIndent(res.Trace, "      ", "  ")
+				fmt.Printf("      %s\n", trace)
+			}
+		default:
+			fmt.Printf("PASS  %s\n", res.Name)
+		}
+	}
+	fmt.Printf("policy %s: %d passed, %d failed\n", policy.Version, len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// 导出历史审批到jsonl归档
+func runPolicyExport(args []string) int {
+	fs := flag.NewFlagSet("policy export", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "Path to the configuration file with api key")
+	history := fs.String("history", "history.jsonl", "Path to the jsonl archive, new records are appended")
+	status := fs.String("status", approval.StatusAgree+","+approval.StatusReject, "Comma separated approval statuses to export")
+	fs.Parse(args)
+
+	wallet, err := approval.NewApprovalWalletFromJson(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration from %s: %v\n", *configPath, err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	added, err := approval.ExportHistory(ctx, wallet.Client, *history, strings.Split(*status, ","))
+	if errors.Is(err, approval.ErrHistoryIncomplete) {
+		fmt.Printf("exported %d approvals to %s\n", added, *history)
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export history: %v\n", err)
+		return 1
+	}
+	fmt.Printf("exported %d approvals to %s\n", added, *history)
+	return 0
+}
+
+// 使用审批规则回放历史审批，输出与人工审批不一致的记录
+func runPolicyReplay(args []string) int {
+	fs := flag.NewFlagSet("policy replay", flag.ExitOnError)
+	policyPath := fs.String("policy", "config.json", "Path to the config or policy file with approvalParams")
+	history := fs.String("history", "history.jsonl", "Path to the jsonl archive exported by policy export")
+	asJson := fs.Bool("json", false, "Print the full report as json")
+	fs.Parse(args)
+
+	policy, err := approval.LoadPolicyFile(*policyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load policy: %v\n", err)
+		return 1
+	}
+	records, err := approval.ReadHistory(*history)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+		return 1
+	}
+	report := approval.ReplayHistory(policy, records)
+	if *asJson {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return 0
+	}
+
+	for _, r := range report.NewlyApproved {
+		fmt.Printf("NEWLY APPROVED  %s: human %s, policy approve (matchedIndex %d)\n", r.RecordId, r.Status, r.MatchedIndex)
+	}
+	for _, r := range report.NewlyRejected {
+		fmt.Printf("NEWLY REJECTED  %s: human %s, policy reject: %s\n", r.RecordId, r.Status, r.Reason)
+	}
+	fmt.Printf("policy %s: %d records, %d same, %d newly approved, %d newly rejected, %d skipped, %d ignored\n",
+		report.PolicyVersion, report.Total, report.Same, len(report.NewlyApproved), len(report.NewlyRejected), len(report.Skipped), len(report.Ignored))
+	return 0
+}
@@ -1,20 +1,39 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/OpenBlockResource/openblock-approval-sdk-go/approval"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		os.Exit(runPolicyCommand(os.Args[2:]))
+	}
+
 	// 定义命令行参数
 	configPath := flag.String("config", "config.json", "Path to the configuration file")
 	checkWallet := flag.String("check-wallet", "", "Check wallet information, e.g. -check-wallet=Solana,ETH ")
 	hdWalletId := flag.String("hd-wallet-id", "", "ID of the HD wallet")
+	dryRun := flag.Bool("dry-run", false, "Evaluate approvals and report decisions without approving or signing")
+	once := flag.Bool("once", false, "Run approver/manager for a single cycle and exit")
+	noWait := flag.Bool("no-wait", false, "Initiator submits the approval and prints the record id without waiting for the result")
+	recordId := flag.String("record-id", "", "Initiator waits for the result of an approval submitted before, e.g. with -no-wait")
+	note := flag.String("note", "", "Initiator approval note, e.g. invoice id, overrides approvalOptions.note in the config")
+	expiredSeconds := flag.Int("expired-seconds", 0, "Initiator approval expiry in seconds, -1 for no expiry, overrides approvalOptions.expiredSeconds in the config")
+	idempotencyKey := flag.String("idempotency-key", "", "Initiator submits the approval only once for the key, re-running resumes the existing approval, persisted with storePath")
+	batchPath := flag.String("batch", "", "Initiator submits approvals from a csv/jsonl file and prints one json result per line")
+	concurrency := flag.Int("concurrency", 5, "Number of approvals submitted at the same time, used with -batch")
+	rateLimit := flag.Float64("rate", 0, "Maximum approvals submitted per second, 0 for no limit, used with -batch")
 	flag.Parse()
 
 	// 从配置文件加载参数
@@ -22,10 +41,20 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load configuration from %s: %v", *configPath, err)
 	}
+	if *dryRun {
+		wallet.Client.DryRun = true
+	}
+	if *idempotencyKey != "" && wallet.StorePath == "" {
+		log.Fatalf("-idempotency-key requires storePath in the config, the key is not kept across restarts without it")
+	}
+	// 收到退出信号时取消正在进行的请求
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	if *checkWallet != "" {
-		walletInfos, err := wallet.Client.GetWalletInfo()
+		walletInfos, err := wallet.Client.GetWalletInfoContext(ctx)
 		if err != nil {
-			log.Fatalf("Failed to get wallet info: %v", err)
+			log.Printf("Failed to get wallet info: %v", err)
 			return
 		}
 		for _, walletInfo := range *walletInfos {
@@ -38,10 +67,63 @@ func main() {
 		return
 	}
 
+	// approver/manager每个周期前检查配置和审批规则文件，修改后重新加载
+	if wallet.Role == "approver" || wallet.Role == "manager" {
+		log.Printf("Using policy version %s", wallet.Policy().Version)
+	}
+
 	for {
+		if wallet.Role == "approver" || wallet.Role == "manager" {
+			reloadPolicy(wallet, *configPath)
+		}
+
 		switch wallet.Role {
 		case "initiator":
-			res, err := wallet.SendApprovalTxInfo(*hdWalletId, wallet.TxInfo)
+			opts := wallet.ApprovalOptions
+			if *note != "" {
+				opts.Note = *note
+			}
+			if *expiredSeconds != 0 {
+				opts.ExpiredSeconds = int32(*expiredSeconds)
+			}
+			if *batchPath != "" {
+				batchOpts := approval.BatchOptions{Concurrency: *concurrency, RateLimit: *rateLimit, NoWait: *noWait}
+				runBatch(ctx, wallet, *batchPath, *hdWalletId, opts, batchOpts)
+				return
+			}
+			if *recordId != "" {
+				res, err := wallet.WaitApprovalContext(ctx, &approval.ApprovalHandle{RecordId: *recordId})
+				if err != nil {
+					log.Printf("Approval fail: %v", err)
+				}
+				log.Printf("Approval response: %s", res)
+				return
+			}
+			if wallet.Client.DryRun {
+				txInfo, _ := json.Marshal(wallet.TxInfo)
+				log.Printf("dry-run, would send approval, hdWalletId: %s, note: %s, txInfo: %s", *hdWalletId, opts.Note, string(txInfo))
+				return
+			}
+			if *noWait {
+				var handle *approval.ApprovalHandle
+				if *idempotencyKey != "" {
+					handle, err = wallet.SubmitApprovalTxInfoWithKeyContext(ctx, *idempotencyKey, *hdWalletId, wallet.TxInfo, opts)
+				} else {
+					handle, err = wallet.SubmitApprovalTxInfoContext(ctx, *hdWalletId, wallet.TxInfo, opts)
+				}
+				if err != nil {
+					log.Printf("Approval fail: %v", err)
+					return
+				}
+				log.Printf("Approval submitted, recordId: %s", handle.RecordId)
+				return
+			}
+			var res string
+			if *idempotencyKey != "" {
+				res, err = wallet.SendApprovalTxInfoWithKeyContext(ctx, *idempotencyKey, *hdWalletId, wallet.TxInfo, opts)
+			} else {
+				res, err = wallet.SendApprovalTxInfoContext(ctx, *hdWalletId, wallet.TxInfo, opts)
+			}
 			if err != nil {
 				log.Printf("Approval fail: %v", err)
 			}
@@ -49,12 +131,12 @@ func main() {
 			return
 
 		case "approver":
-			if err := wallet.AutoApprove(); err != nil {
+			if err := wallet.AutoApproveContext(ctx); err != nil {
 				log.Printf("Auto approval failed: %v", err)
 			}
 
 		case "manager":
-			if err := wallet.AutoSign(); err != nil {
+			if err := wallet.AutoSignContext(ctx); err != nil {
 				log.Printf("Auto sign failed: %v", err)
 			}
 
@@ -62,6 +144,82 @@ func main() {
 			log.Fatalf("Unknown role: %s", wallet.Role)
 		}
 
-		time.Sleep(5 * time.Second)
+		if *once {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutting down")
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// 重新加载审批规则，新规则无效时继续使用原来的规则
+func reloadPolicy(wallet *approval.ApprovalWallet, path string) {
+	version := wallet.Policy().Version
+	changed, err := wallet.ReloadPolicy(path)
+	if err != nil {
+		log.Printf("Failed to reload policy, keep version %s: %v", version, err)
+		return
+	}
+	if changed {
+		log.Printf("Policy reloaded from %s, version %s -> %s", path, version, wallet.Policy().Version)
+	}
+}
+
+// 批量发起审批，每笔结果按json输出一行，hdWalletId、备注等为空的记录使用命令行和配置中的值
+func runBatch(ctx context.Context, wallet *approval.ApprovalWallet, path, hdWalletId string, approvalOpts approval.ApprovalOptions, opts approval.BatchOptions) {
+	items, err := approval.ReadBatchFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read batch: %v", err)
+	}
+	for i := range items {
+		if items[i].HdWalletId == "" {
+			items[i].HdWalletId = hdWalletId
+		}
+		if items[i].Note == "" {
+			items[i].Note = approvalOpts.Note
+		}
+		if items[i].ExpiredSeconds == 0 {
+			items[i].ExpiredSeconds = approvalOpts.ExpiredSeconds
+		}
+		if items[i].Action == "" {
+			items[i].Action = approvalOpts.Action
+		}
+	}
+	if wallet.Client.DryRun {
+		for _, item := range items {
+			txInfo, _ := json.Marshal(item.TxInfo)
+			log.Printf("dry-run, would send approval, id: %s, hdWalletId: %s, note: %s, txInfo: %s", item.Id, item.HdWalletId, item.Note, string(txInfo))
+		}
+		return
+	}
+
+	log.Printf("Submitting %d approvals from %s", len(items), path)
+	results, err := wallet.SendBatchContext(ctx, items, opts)
+	if err != nil {
+		log.Printf("Batch approval fail: %v", err)
+	}
+	failed := 0
+	for _, r := range results {
+		out := struct {
+			Id       string `json:"id"`
+			RecordId string `json:"recordId,omitempty"`
+			Status   string `json:"status,omitempty"`
+			Result   string `json:"result,omitempty"`
+			Error    string `json:"error,omitempty"`
+		}{Id: r.Id, Status: r.Status, Result: r.Result}
+		if r.Handle != nil {
+			out.RecordId = r.Handle.RecordId
+		}
+		if r.Err != nil {
+			out.Error = r.Err.Error()
+			failed++
+		}
+		line, _ := json.Marshal(out)
+		fmt.Println(string(line))
 	}
+	log.Printf("Batch finished, total: %d, failed: %d", len(results), failed)
 }
@@ -9,6 +9,7 @@ require (
 	github.com/ethereum/go-ethereum v1.16.7
 	github.com/fardream/go-bcs v0.9.0
 	github.com/gagliardetto/solana-go v1.14.0
+	github.com/google/uuid v1.6.0
 	github.com/minio/blake2b-simd v0.0.0-20160723061019-3f5f724cb5b1
 	github.com/shopspring/decimal v1.3.1
 	github.com/stretchr/testify v1.10.0
@@ -29,7 +30,6 @@ require (
 	github.com/fatih/color v1.16.0 // indirect
 	github.com/gagliardetto/binary v0.8.0 // indirect
 	github.com/gagliardetto/treeout v0.1.4 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/holiman/uint256 v1.3.2 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/klauspost/compress v1.16.0 // indirect